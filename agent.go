@@ -0,0 +1,386 @@
+package main
+
+// The agent remembers the password, so that it only has to be entered
+// once, and caches the keys derived from it so that argon2 only runs
+// once per file. Like ssh-agent, it listens on a Unix socket in a
+// private directory, whose path is given to clients in $SYM_AUTH_SOCK.
+// Each connection carries a single JSON request and response. The
+// password and keys are kept in memory that is locked into RAM, and
+// are forgotten after a timeout or when the agent is locked.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+const (
+	agentSockEnv = "SYM_AUTH_SOCK"
+
+	// agentDaemonEnv is set for the background agent process, which
+	// inherits the listening socket as file descriptor 3.
+	agentDaemonEnv = "SYM_AGENT_DAEMON"
+
+	agentTimeout = time.Minute
+)
+
+type agentRequest struct {
+	Op       string `json:"op"` // add, key, status or lock
+	Password []byte `json:"password,omitempty"`
+	Salt     []byte `json:"salt,omitempty"`
+	Norm     byte   `json:"norm,omitempty"`
+}
+
+type agentResponse struct {
+	Key      []byte `json:"key,omitempty"`
+	Unlocked bool   `json:"unlocked,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// agentState holds the secrets of an agent.
+type agentState struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	password []byte            // in locked memory
+	keys     map[string][]byte // derived keys by salt and form, in locked memory
+	timer    *time.Timer
+}
+
+func (s *agentState) add(password []byte) error {
+	locked, err := lockedAlloc(len(password))
+	if err != nil {
+		return err
+	}
+	copy(locked, password)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clearLocked()
+	s.password = locked
+	s.keys = make(map[string][]byte)
+	s.timer = time.AfterFunc(s.ttl, s.lock)
+	return nil
+}
+
+func (s *agentState) lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clearLocked()
+}
+
+// clearLocked forgets all secrets. s.mu must be held.
+func (s *agentState) clearLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.password != nil {
+		lockedFree(s.password)
+		s.password = nil
+	}
+	for _, key := range s.keys {
+		lockedFree(key)
+	}
+	s.keys = nil
+}
+
+// key returns a copy of the key for the given salt, with the password
+// in the given normalization form.
+func (s *agentState) key(salt []byte, form byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.password == nil {
+		return nil, errors.New("agent is locked")
+	}
+	password := normalizePassword(string(s.password), form)
+	if password == string(s.password) {
+		form = normNone
+	}
+	id := string(salt) + string(form)
+	if key, ok := s.keys[id]; ok {
+		return append([]byte(nil), key...), nil
+	}
+	key := hashPassword(password, salt)
+	locked, err := lockedAlloc(len(key))
+	if err != nil {
+		return nil, err
+	}
+	copy(locked, key)
+	s.keys[id] = locked
+	return key, nil
+}
+
+func (s *agentState) handle(req *agentRequest) *agentResponse {
+	var err error
+	resp := new(agentResponse)
+	switch req.Op {
+	case "add":
+		err = s.add(req.Password)
+	case "key":
+		resp.Key, err = s.key(req.Salt, req.Norm)
+	case "status":
+		s.mu.Lock()
+		resp.Unlocked = s.password != nil
+		s.mu.Unlock()
+	case "lock":
+		s.lock()
+	default:
+		err = fmt.Errorf("unknown request %q", req.Op)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+func (s *agentState) serveConn(conn *net.UnixConn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	var resp *agentResponse
+	if err := checkPeer(conn); err != nil {
+		resp = &agentResponse{Error: err.Error()}
+	} else {
+		var req agentRequest
+		if err := json.NewDecoder(conn).Decode(&req); err != nil {
+			return
+		}
+		resp = s.handle(&req)
+		clear(req.Password)
+	}
+	json.NewEncoder(conn).Encode(resp)
+	clear(resp.Key)
+}
+
+// serveAgent serves requests on l until ctx is canceled.
+func serveAgent(ctx context.Context, l *net.UnixListener, ttl time.Duration) error {
+	s := &agentState{ttl: ttl}
+	defer s.lock()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+type agentClient struct {
+	sock string
+}
+
+// newAgentClient returns a client for the agent listening on sock, or
+// nil if sock is empty.
+func newAgentClient(sock string) *agentClient {
+	if sock == "" {
+		return nil
+	}
+	return &agentClient{sock: sock}
+}
+
+func (a *agentClient) call(req *agentRequest) (*agentResponse, error) {
+	conn, err := net.Dial("unix", a.sock)
+	if err != nil {
+		return nil, fmt.Errorf("connect to agent: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("agent: %s", err)
+	}
+	var resp agentResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("agent: %s", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("agent: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// unlocked reports whether the agent holds a password. It is false if
+// there is no agent, or it can't be reached.
+func (a *agentClient) unlocked() bool {
+	if a == nil {
+		return false
+	}
+	resp, err := a.call(&agentRequest{Op: "status"})
+	return err == nil && resp.Unlocked
+}
+
+// remember gives password to the agent, if there is one. Failures are
+// only warnings, since the agent is just a cache.
+func (a *agentClient) remember(password string) {
+	if a == nil {
+		return
+	}
+	if _, err := a.call(&agentRequest{Op: "add", Password: []byte(password)}); err != nil {
+		fmt.Fprintf(os.Stderr, "sym: warning: %s\n", err)
+	}
+}
+
+// key is a keyFunc that gets the key from the agent.
+func (a *agentClient) key(h *header) ([]byte, error) {
+	if h.mirror {
+		return nil, errMirrorFile
+	}
+	if h.keyfile {
+		return nil, errKeyfileRequired
+	}
+	if h.shares {
+		return nil, errSharesRequired
+	}
+	resp, err := a.call(&agentRequest{Op: "key", Salt: h.salt, Norm: h.norm})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Key, nil
+}
+
+func (a *agentClient) lock() error {
+	_, err := a.call(&agentRequest{Op: "lock"})
+	return err
+}
+
+type agentCmd struct {
+	ttl        time.Duration
+	lock       bool
+	foreground bool
+
+	stdout io.Writer
+}
+
+func (*agentCmd) Name() string     { return "agent" }
+func (*agentCmd) Synopsis() string { return "remember the password for a while" }
+func (*agentCmd) Usage() string {
+	return `usage: sym agent [OPTION]...
+Start an agent that remembers the password, so that sym enc and sym dec
+only ask for it once. The agent runs in the background and prints the
+shell commands to set $SYM_AUTH_SOCK, which tells sym how to reach it.
+Example:
+  eval "$(sym agent)"
+
+When $SYM_AUTH_SOCK is set and the agent holds a password, enc and dec
+use it instead of prompting. enc says so on stderr, and dec asks for
+the password of files that the agent's password doesn't decrypt. A
+password that was entered at the prompt is given to the agent. Keys derived from the password are cached too,
+so decrypting the same file again is fast.
+
+The agent forgets the password after the time given by -ttl, or when
+sym agent -lock is run.
+
+`
+}
+
+func (c *agentCmd) SetFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&c.ttl, "ttl", time.Hour, "forget the password after `duration`")
+	fs.BoolVar(&c.lock, "lock", false, "make the running agent forget the password")
+	fs.BoolVar(&c.foreground, "foreground", false, "run the agent in the foreground")
+}
+
+// listenAgent creates a socket in a new private directory.
+func listenAgent() (*net.UnixListener, error) {
+	dir, err := os.MkdirTemp("", "sym-agent-")
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "agent.sock"), Net: "unix"})
+	if err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	return l, nil
+}
+
+// startDaemon starts the agent in the background, passing it l.
+func (c *agentCmd) startDaemon(l *net.UnixListener) error {
+	f, err := l.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, "agent", "-ttl", c.ttl.String())
+	cmd.Env = append(os.Environ(), agentDaemonEnv+"=1")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.SysProcAttr = daemonAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func (c *agentCmd) run(ctx context.Context, args ...string) error {
+	if len(args) > 0 {
+		return usageErr("agent does not take any arguments")
+	}
+	if c.lock {
+		a := newAgentClient(os.Getenv(agentSockEnv))
+		if a == nil {
+			return usageErr("$%s is not set", agentSockEnv)
+		}
+		return a.lock()
+	}
+	if c.ttl <= 0 {
+		return usageErr("-ttl must be positive")
+	}
+	if os.Getenv(agentDaemonEnv) != "" {
+		f := os.NewFile(3, "agent socket")
+		fl, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		l, ok := fl.(*net.UnixListener)
+		if !ok {
+			return errors.New("inherited socket is not a Unix socket")
+		}
+		return c.serve(ctx, l)
+	}
+	l, err := listenAgent()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "%s='%s'; export %[1]s;\n", agentSockEnv, l.Addr())
+	if c.foreground {
+		return c.serve(ctx, l)
+	}
+	// The daemon removes the socket when it exits.
+	l.SetUnlinkOnClose(false)
+	defer l.Close()
+	return c.startDaemon(l)
+}
+
+// serve serves requests on l, and removes the socket and its directory
+// when done.
+func (c *agentCmd) serve(ctx context.Context, l *net.UnixListener) error {
+	sock := l.Addr().String()
+	defer os.Remove(filepath.Dir(sock))
+	l.SetUnlinkOnClose(true)
+	defer l.Close()
+	return serveAgent(ctx, l, c.ttl)
+}
+
+func (c *agentCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return exitStatus(ctx, c.run(ctx, f.Args()...))
+}
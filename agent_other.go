@@ -0,0 +1,29 @@
+//go:build !unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// lockedAlloc allocates n bytes of memory. Locking memory is not
+// supported on this platform.
+func lockedAlloc(n int) ([]byte, error) {
+	return make([]byte, n), nil
+}
+
+// lockedFree zeroes memory from lockedAlloc.
+func lockedFree(b []byte) {
+	clear(b)
+}
+
+func daemonAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// checkPeer is a no-op, the socket is protected by the permissions of
+// its directory.
+func checkPeer(conn *net.UnixConn) error {
+	return nil
+}
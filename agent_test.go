@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAgent(t *testing.T) {
+	t.Parallel()
+
+	l, err := listenAgent()
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	sock := l.Addr().String()
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() {
+		done <- (&agentCmd{ttl: time.Hour}).serve(ctx, l)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Agent failed: %s", err)
+		}
+		if _, err := os.Stat(filepath.Dir(sock)); !os.IsNotExist(err) {
+			t.Errorf("Agent did not remove its socket directory")
+		}
+	})
+
+	const password = "asdf"
+	fileName := filepath.Join(t.TempDir(), "file")
+	mustWriteFile(t, fileName, []byte("test file content"))
+	if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
+		t.Fatalf("encryptFile failed: %s", err)
+	}
+	mustRemove(t, fileName)
+
+	a := newAgentClient(sock)
+	if a.unlocked() {
+		t.Fatal("New agent is unlocked")
+	}
+	a.remember(password)
+	if !a.unlocked() {
+		t.Fatal("Agent is locked after adding a password")
+	}
+	noPrompt := func(string) (string, error) {
+		t.Error("dec prompted for a password while the agent is unlocked")
+		return password, nil
+	}
+	if err := (&decCmd{keys: keyFlags{agent: a, passwordIn: noPrompt}}).run(t.Context(), fileName+".enc"); err != nil {
+		t.Fatalf("Failed to decrypt with the agent's key: %s", err)
+	}
+
+	// A file encrypted with another password is decrypted with the
+	// password from the prompt.
+	otherName := filepath.Join(t.TempDir(), "other")
+	mustWriteFile(t, otherName, []byte("other file content"))
+	if err := (&encCmd{}).encryptFile(t.Context(), otherName, passwordKey("other")); err != nil {
+		t.Fatalf("encryptFile failed: %s", err)
+	}
+	mustRemove(t, otherName)
+	prompts := 0
+	prompt := func(string) (string, error) {
+		prompts++
+		return "other", nil
+	}
+	if err := (&decCmd{keys: keyFlags{agent: a, passwordIn: prompt}}).run(t.Context(), otherName+".enc"); err != nil {
+		t.Fatalf("Failed to decrypt a file with another password than the agent's: %s", err)
+	}
+	if prompts != 1 {
+		t.Errorf("dec prompted %d times for a file with another password, want 1", prompts)
+	}
+	if err := a.lock(); err != nil {
+		t.Fatalf("Failed to lock the agent: %s", err)
+	}
+	if a.unlocked() {
+		t.Error("Agent is unlocked after locking it")
+	}
+}
+
+func TestAgentState_TTL(t *testing.T) {
+	t.Parallel()
+
+	s := &agentState{ttl: time.Millisecond}
+	if err := s.add([]byte("asdf")); err != nil {
+		t.Fatalf("Failed to add password: %s", err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if resp := s.handle(&agentRequest{Op: "status"}); !resp.Unlocked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Agent did not forget the password after its TTL")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := s.key(make([]byte, saltSize), normNFC); err == nil {
+		t.Error("Agent returned a key after its TTL, want error")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockedAlloc allocates n bytes of memory that is locked into RAM, so
+// that it is never written to swap. It must be freed with lockedFree.
+func lockedAlloc(n int) ([]byte, error) {
+	b, err := unix.Mmap(-1, 0, max(n, 1), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Mlock(b); err != nil {
+		unix.Munmap(b)
+		return nil, err
+	}
+	return b[:n], nil
+}
+
+// lockedFree zeroes and frees memory from lockedAlloc.
+func lockedFree(b []byte) {
+	b = b[:cap(b)]
+	clear(b)
+	unix.Munlock(b)
+	unix.Munmap(b)
+}
+
+// daemonAttr returns the attributes for starting the agent in the
+// background, detached from the terminal.
+func daemonAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,490 @@
+package main
+
+// Directories are encrypted as a tar archive. Entry names are relative
+// to the directory being archived, so extracting dir.tar.enc recreates
+// the contents of dir inside a new directory.
+//
+// After the end of the tar stream, the archive has an index of the
+// offset of each entry, followed by an 8 byte length of the index and
+// indexMagic. Tools that read the tar stream ignore the trailing index,
+// but it allows extracting single entries without scanning the whole
+// archive.
+//
+// Extraction goes through an os.Root, so no file is ever created
+// outside the output directory. Symlinks are created last, after
+// checking that their targets resolve inside the output directory, so
+// that other entries can't be written through them.
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	maxSymlinks = 40
+
+	indexMagic       = "symindex"
+	indexTrailerSize = 8 + len(indexMagic)
+)
+
+type indexEntry struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(buf []byte) (int, error) {
+	n, err := w.w.Write(buf)
+	w.n += int64(n)
+	return n, err
+}
+
+// writeArchive writes the directory tree rooted at dir to w as a tar
+// stream, followed by the index. Only directories, regular files,
+// symlinks and hardlinks are archived; other file types are skipped.
+func writeArchive(w io.Writer, dir string) error {
+	cw := &countingWriter{w: w}
+	tw := tar.NewWriter(cw)
+	links := make(map[fileID]string)
+	var index []indexEntry
+	err := filepath.WalkDir(dir, func(fileName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fileName)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var target string
+		switch {
+		case fi.Mode().IsRegular(), fi.IsDir():
+		case fi.Mode()&fs.ModeSymlink != 0:
+			if target, err = os.Readlink(fileName); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, target)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if id, ok := hardLinkID(fi); ok && fi.Mode().IsRegular() {
+			if first, ok := links[id]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				links[id] = hdr.Name
+			}
+		}
+		// Flush the padding of the previous entry to get the offset.
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		index = append(index, indexEntry{Name: hdr.Name, Offset: cw.n})
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+			return fmt.Errorf("%s: %s", fileName, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(len(b)))
+	b = append(b, indexMagic...)
+	_, err = w.Write(b)
+	return err
+}
+
+// readIndex reads the index from the end of an archive. It returns nil
+// if the archive has no index.
+func readIndex(r io.ReaderAt, size int64) ([]indexEntry, error) {
+	if size < int64(indexTrailerSize) {
+		return nil, nil
+	}
+	trailer := make([]byte, indexTrailerSize)
+	if _, err := r.ReadAt(trailer, size-int64(len(trailer))); err != nil {
+		return nil, err
+	}
+	if string(trailer[8:]) != indexMagic {
+		return nil, nil
+	}
+	n := binary.BigEndian.Uint64(trailer)
+	if n > uint64(size)-uint64(len(trailer)) {
+		return nil, errors.New("malformed archive index")
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, size-int64(len(trailer))-int64(n)); err != nil {
+		return nil, err
+	}
+	var index []indexEntry
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("malformed archive index: %s", err)
+	}
+	return index, nil
+}
+
+// archiveMatcher selects archive entries using path.Match patterns. A
+// pattern that matches a directory selects everything inside it.
+type archiveMatcher struct {
+	patterns []string
+	matched  []bool
+}
+
+func newArchiveMatcher(patterns []string) (*archiveMatcher, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, usageErr("bad pattern %q", pattern)
+		}
+	}
+	return &archiveMatcher{
+		patterns: patterns,
+		matched:  make([]bool, len(patterns)),
+	}, nil
+}
+
+func (m *archiveMatcher) match(name string) bool {
+	if m == nil {
+		return true
+	}
+	ok := false
+	for p := strings.TrimSuffix(name, "/"); p != "." && p != "/"; p = path.Dir(p) {
+		for i, pattern := range m.patterns {
+			if matched, _ := path.Match(strings.TrimSuffix(pattern, "/"), p); matched {
+				m.matched[i] = true
+				ok = true
+			}
+		}
+	}
+	return ok
+}
+
+// check returns an error if any pattern didn't match any entries.
+func (m *archiveMatcher) check() error {
+	if m == nil {
+		return nil
+	}
+	for i, matched := range m.matched {
+		if !matched {
+			return fmt.Errorf("no archive entries match %q", m.patterns[i])
+		}
+	}
+	return nil
+}
+
+// archivePath validates the name of an archive entry and converts it to
+// a local path.
+func archivePath(name string) (string, error) {
+	p := filepath.FromSlash(strings.TrimSuffix(name, "/"))
+	if !filepath.IsLocal(p) {
+		return "", fmt.Errorf("refusing to extract %q: path escapes the output directory", name)
+	}
+	return filepath.Clean(p), nil
+}
+
+type archiveExtractor struct {
+	root    *os.Root
+	force   bool
+	matcher *archiveMatcher
+
+	// symlinks maps the name of each pending symlink to its target.
+	symlinks map[string]string
+	dirs     []*tar.Header
+}
+
+func newArchiveExtractor(dir string, force bool, matcher *archiveMatcher) (*archiveExtractor, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveExtractor{
+		root:     root,
+		force:    force,
+		matcher:  matcher,
+		symlinks: make(map[string]string),
+	}, nil
+}
+
+// extractArchive extracts the tar stream r into dir, which must already
+// exist. If force is set, existing files are overwritten. If matcher is
+// not nil, only the matching entries are extracted.
+func extractArchive(r io.Reader, dir string, force bool, matcher *archiveMatcher) error {
+	e, err := newArchiveExtractor(dir, force, matcher)
+	if err != nil {
+		return err
+	}
+	defer e.root.Close()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !matcher.match(hdr.Name) {
+			continue
+		}
+		if err := e.extract(hdr, tr); err != nil {
+			return err
+		}
+	}
+	// Read the rest of the stream, so that it gets authenticated.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	if err := matcher.check(); err != nil {
+		return err
+	}
+	return e.finish()
+}
+
+// extractIndexed is like extractArchive, but uses the archive index to
+// seek directly to the matching entries.
+func extractIndexed(r io.ReaderAt, size int64, index []indexEntry, dir string, force bool, matcher *archiveMatcher) error {
+	e, err := newArchiveExtractor(dir, force, matcher)
+	if err != nil {
+		return err
+	}
+	defer e.root.Close()
+	for _, entry := range index {
+		if !matcher.match(entry.Name) {
+			continue
+		}
+		if entry.Offset < 0 || entry.Offset >= size {
+			return errors.New("malformed archive index")
+		}
+		tr := tar.NewReader(io.NewSectionReader(r, entry.Offset, size-entry.Offset))
+		hdr, err := tr.Next()
+		if err != nil {
+			return err
+		}
+		if hdr.Name != entry.Name {
+			return fmt.Errorf("malformed archive index: entry %q has name %q", entry.Name, hdr.Name)
+		}
+		if err := e.extract(hdr, tr); err != nil {
+			return err
+		}
+	}
+	if err := matcher.check(); err != nil {
+		return err
+	}
+	return e.finish()
+}
+
+func (e *archiveExtractor) extract(hdr *tar.Header, r io.Reader) error {
+	name, err := archivePath(hdr.Name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return nil
+	}
+	if _, ok := e.symlinks[name]; ok {
+		return fmt.Errorf("duplicate archive entry %q", hdr.Name)
+	}
+	if dir := filepath.Dir(name); dir != "." {
+		if err := e.root.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := e.root.Mkdir(name, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		e.dirs = append(e.dirs, hdr)
+		return nil
+	case tar.TypeReg:
+		if err := e.writeFile(name, r); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		oldName, err := archivePath(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := e.removeExisting(name); err != nil {
+			return err
+		}
+		if _, err := e.root.Lstat(oldName); errors.Is(err, fs.ErrNotExist) && e.matcher != nil {
+			return fmt.Errorf("%q is a hard link to %q, which was not selected", hdr.Name, hdr.Linkname)
+		}
+		return e.root.Link(oldName, name)
+	case tar.TypeSymlink:
+		e.symlinks[name] = hdr.Linkname
+		return nil
+	default:
+		return fmt.Errorf("unsupported archive entry type %q for %q", hdr.Typeflag, hdr.Name)
+	}
+	if err := e.root.Chmod(name, hdr.FileInfo().Mode()); err != nil {
+		return err
+	}
+	return e.root.Chtimes(name, hdr.AccessTime, hdr.ModTime)
+}
+
+func (e *archiveExtractor) writeFile(name string, r io.Reader) (err error) {
+	fileOpts := os.O_CREATE | os.O_WRONLY
+	if e.force {
+		fileOpts |= os.O_TRUNC
+	} else {
+		fileOpts |= os.O_EXCL
+	}
+	f, err := e.root.OpenFile(name, fileOpts, 0600)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("output file %q exists (use -f to overwrite)", name)
+		}
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (e *archiveExtractor) removeExisting(name string) error {
+	if _, err := e.root.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if !e.force {
+		return fmt.Errorf("output file %q exists (use -f to overwrite)", name)
+	}
+	return e.root.Remove(name)
+}
+
+// finish creates the pending symlinks and sets directory modes and
+// times, which can't be done until everything inside them is extracted.
+func (e *archiveExtractor) finish() error {
+	for _, name := range slices.Sorted(maps.Keys(e.symlinks)) {
+		target := e.symlinks[name]
+		if err := e.checkSymlink(name, target); err != nil {
+			return err
+		}
+		if err := e.removeExisting(name); err != nil {
+			return err
+		}
+		if err := e.root.Symlink(target, name); err != nil {
+			return err
+		}
+	}
+	for _, hdr := range slices.Backward(e.dirs) {
+		name, _ := archivePath(hdr.Name)
+		if err := e.root.Chmod(name, hdr.FileInfo().Mode()); err != nil {
+			return err
+		}
+		if err := e.root.Chtimes(name, hdr.AccessTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSymlink returns an error if the symlink name -> target would
+// resolve to a path outside the output directory, following both
+// symlinks already on disk and symlinks from the archive.
+func (e *archiveExtractor) checkSymlink(name, target string) error {
+	var dir []string
+	if d := path.Dir(filepath.ToSlash(name)); d != "." {
+		dir = strings.Split(d, "/")
+	}
+	if _, ok := e.resolve(dir, target, 0); !ok {
+		return fmt.Errorf("refusing to extract symlink %q -> %q: target escapes the output directory", name, target)
+	}
+	return nil
+}
+
+// resolve resolves the slash-separated path target relative to the
+// directory dir, and returns the resulting path components. It returns
+// false if the path escapes the output directory at any point.
+func (e *archiveExtractor) resolve(dir []string, target string, depth int) ([]string, bool) {
+	if depth > maxSymlinks || path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) {
+		return nil, false
+	}
+	for _, elem := range strings.Split(target, "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(dir) == 0 {
+				return nil, false
+			}
+			dir = dir[:len(dir)-1]
+			continue
+		}
+		next := append(slices.Clip(dir), elem)
+		link, ok := e.readlink(filepath.Join(next...))
+		if !ok {
+			dir = next
+			continue
+		}
+		if dir, ok = e.resolve(dir, link, depth+1); !ok {
+			return nil, false
+		}
+	}
+	return dir, true
+}
+
+func (e *archiveExtractor) readlink(name string) (string, bool) {
+	if target, ok := e.symlinks[name]; ok {
+		return target, true
+	}
+	fi, err := e.root.Lstat(name)
+	if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		return "", false
+	}
+	target, err := e.root.Readlink(name)
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(target), true
+}
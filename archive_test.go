@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mustSymlink(t *testing.T, target, name string) {
+	t.Helper()
+	if err := os.Symlink(target, name); err != nil {
+		t.Fatalf("Failed to create symlink: %s", err)
+	}
+}
+
+func mustMkdir(t *testing.T, path string) {
+	t.Helper()
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+}
+
+func TestEncryptDir(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	dir := filepath.Join(t.TempDir(), "dir")
+	mustMkdir(t, dir)
+	mustMkdir(t, filepath.Join(dir, "sub"))
+	mustWriteFile(t, filepath.Join(dir, "sub", "file"), []byte("file content"))
+	mustChmod(t, filepath.Join(dir, "sub", "file"), 0751)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "sub", "file"), mtime, mtime); err != nil {
+		t.Fatalf("Failed to set mtime: %s", err)
+	}
+	if err := os.Link(filepath.Join(dir, "sub", "file"), filepath.Join(dir, "hardlink")); err != nil {
+		t.Fatalf("Failed to create hardlink: %s", err)
+	}
+	mustSymlink(t, "sub/file", filepath.Join(dir, "symlink"))
+
+	if err := (&encCmd{recursive: true}).encryptFile(t.Context(), dir+"/", passwordKey(password)); err != nil {
+		t.Fatalf("encryptFile failed: %s", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("Failed to remove directory: %s", err)
+	}
+	if err := (&decCmd{}).decryptFile(t.Context(), dir+".tar.enc", passwordKey(password)); err != nil {
+		t.Fatalf("decryptFile failed: %s", err)
+	}
+
+	if got := mustReadFile(t, filepath.Join(dir, "sub", "file")); string(got) != "file content" {
+		t.Errorf("Extracted file has content %q, want %q", got, "file content")
+	}
+	fi, err := os.Stat(filepath.Join(dir, "sub", "file"))
+	if err != nil {
+		t.Fatalf("Failed to stat extracted file: %s", err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0751); got != want {
+		t.Errorf("Extracted file has mode %s, want %s", got, want)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("Extracted file has mtime %s, want %s", fi.ModTime(), mtime)
+	}
+	linkFi, err := os.Stat(filepath.Join(dir, "hardlink"))
+	if err != nil {
+		t.Fatalf("Failed to stat hardlink: %s", err)
+	}
+	if !os.SameFile(fi, linkFi) {
+		t.Errorf("Hardlink was not preserved")
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "symlink")); err != nil || target != "sub/file" {
+		t.Errorf("Readlink(symlink) = %q, %v, want %q", target, err, "sub/file")
+	}
+}
+
+func TestEncryptFile_DirWithoutRecursive(t *testing.T) {
+	t.Parallel()
+
+	if err := (&encCmd{}).encryptFile(t.Context(), t.TempDir(), passwordKey("asdf")); err == nil {
+		t.Error("encryptFile succeeded for a directory without -r, want error")
+	}
+}
+
+func TestExtractArchive_Unsafe(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		headers []*tar.Header
+	}{{
+		desc:    "DotDot",
+		headers: []*tar.Header{{Name: "../escape", Typeflag: tar.TypeReg}},
+	}, {
+		desc:    "Absolute",
+		headers: []*tar.Header{{Name: "/tmp/escape", Typeflag: tar.TypeReg}},
+	}, {
+		desc:    "AbsoluteSymlink",
+		headers: []*tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+	}, {
+		desc:    "SymlinkDotDot",
+		headers: []*tar.Header{{Name: "a/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}},
+	}, {
+		desc: "SymlinkThroughSymlink",
+		headers: []*tar.Header{
+			{Name: "dot", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dot/../etc"},
+		},
+	}, {
+		desc: "WriteThroughSymlink",
+		headers: []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "link/../../escape", Typeflag: tar.TypeReg},
+		},
+	}, {
+		desc:    "HardlinkDotDot",
+		headers: []*tar.Header{{Name: "link", Typeflag: tar.TypeLink, Linkname: "../escape"}},
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			buf := new(bytes.Buffer)
+			tw := tar.NewWriter(buf)
+			for _, hdr := range tc.headers {
+				hdr.Mode = 0644
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatalf("Failed to write tar header: %s", err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("Failed to write tar: %s", err)
+			}
+			dir := filepath.Join(t.TempDir(), "out")
+			mustMkdir(t, dir)
+			if err := extractArchive(buf, dir, false, nil); err == nil {
+				t.Error("extractArchive succeeded for unsafe archive, want error")
+			}
+		})
+	}
+}
+
+func TestDecryptFile_SelectEntries(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		noIndex bool
+	}{{
+		desc: "Indexed",
+	}, {
+		desc:    "Scan",
+		noIndex: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			const password = "asdf"
+			dir := filepath.Join(t.TempDir(), "dir")
+			mustMkdir(t, dir)
+			mustMkdir(t, filepath.Join(dir, "sub"))
+			mustWriteFile(t, filepath.Join(dir, "a.txt"), []byte("a"))
+			mustWriteFile(t, filepath.Join(dir, "b.txt"), []byte("b"))
+			mustWriteFile(t, filepath.Join(dir, "c.dat"), []byte("c"))
+			mustWriteFile(t, filepath.Join(dir, "sub", "d"), []byte("d"))
+
+			tarball := new(bytes.Buffer)
+			if err := writeArchive(tarball, dir); err != nil {
+				t.Fatalf("writeArchive failed: %s", err)
+			}
+			if tc.noIndex {
+				n := bytes.LastIndex(tarball.Bytes(), []byte("[{"))
+				tarball.Truncate(n)
+			}
+			encrypted := new(bytes.Buffer)
+			writer := newEncryptingWriter(t.Context(), encrypted, password)
+			writer.header.archive = true
+			if _, err := writer.Write(tarball.Bytes()); err != nil {
+				t.Fatalf("Failed to encrypt: %s", err)
+			}
+			if err := writer.close(); err != nil {
+				t.Fatalf("Failed to encrypt: %s", err)
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				t.Fatalf("Failed to remove directory: %s", err)
+			}
+			mustWriteFile(t, dir+".tar.enc", encrypted.Bytes())
+
+			if err := (&decCmd{patterns: []string{"*.txt", "sub"}}).decryptFile(t.Context(), dir+".tar.enc", passwordKey(password)); err != nil {
+				t.Fatalf("decryptFile failed: %s", err)
+			}
+			for _, name := range []string{"a.txt", "b.txt", "sub/d"} {
+				mustReadFile(t, filepath.Join(dir, name))
+			}
+			if _, err := os.Stat(filepath.Join(dir, "c.dat")); !os.IsNotExist(err) {
+				t.Errorf("Unselected file c.dat was extracted")
+			}
+		})
+	}
+}
+
+func TestDecryptFile_SelectEntries_NoMatch(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	dir := filepath.Join(t.TempDir(), "dir")
+	mustMkdir(t, dir)
+	mustWriteFile(t, filepath.Join(dir, "a.txt"), []byte("a"))
+	if err := (&encCmd{recursive: true}).encryptFile(t.Context(), dir, passwordKey(password)); err != nil {
+		t.Fatalf("encryptFile failed: %s", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("Failed to remove directory: %s", err)
+	}
+	if err := (&decCmd{patterns: []string{"nothing"}}).decryptFile(t.Context(), dir+".tar.enc", passwordKey(password)); err == nil {
+		t.Error("decryptFile succeeded with a pattern that matches nothing, want error")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Output directory was not cleaned up after error")
+	}
+}
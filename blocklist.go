@@ -0,0 +1,259 @@
+package main
+
+// A blocklist is a Bloom filter of passwords from breach corpora, so
+// that enc can refuse them without network access and without keeping
+// the passwords themselves around. The file starts with blocklistMagic,
+// followed by the number of hash functions as one byte, the number of
+// bits as a big-endian uint64, and the bits. Passwords are normalized
+// like for hashing and hashed with SHA-256, and the bit indexes are
+// derived from the hash by double hashing.
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/google/subcommands"
+)
+
+const (
+	blocklistMagic      = "\x00sym\x00blocklist1\n"
+	blocklistHeaderSize = len(blocklistMagic) + 1 + 8
+
+	// blocklistEnv names the blocklist enc uses when -blocklist is not
+	// given.
+	blocklistEnv = "SYM_BLOCKLIST"
+
+	defaultFalsePositive = 1e-6
+)
+
+// blocklistHash returns the hashes that the bit indexes for password
+// are derived from.
+func blocklistHash(password string) (uint64, uint64) {
+	h := sha256.Sum256([]byte(normalizePassword(password, normNFC)))
+	// The second hash is odd, so that the indexes don't repeat early.
+	return binary.BigEndian.Uint64(h[:8]), binary.BigEndian.Uint64(h[8:16]) | 1
+}
+
+// blocklistSize returns the number of bits and hash functions for a
+// blocklist of n passwords with the given false positive rate.
+func blocklistSize(n int, falsePositive float64) (uint64, int) {
+	nBits := math.Ceil(-float64(n) * math.Log(falsePositive) / (math.Ln2 * math.Ln2))
+	k := math.Round(nBits / float64(n) * math.Ln2)
+	return max(uint64(nBits), 8), int(min(max(k, 1), math.MaxUint8))
+}
+
+// blocklisted reports whether password is in the blocklist fileName.
+// Like any Bloom filter, it has false positives, but no false
+// negatives.
+func blocklisted(fileName, password string) (bool, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	header := make([]byte, blocklistHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:len(blocklistMagic)]) != blocklistMagic {
+		return false, fmt.Errorf("%q is not a blocklist", fileName)
+	}
+	k := int(header[len(blocklistMagic)])
+	nBits := binary.BigEndian.Uint64(header[len(blocklistMagic)+1:])
+	if k == 0 || nBits == 0 || uint64(fi.Size()-int64(blocklistHeaderSize)) != (nBits+7)/8 {
+		return false, fmt.Errorf("blocklist %q is malformed", fileName)
+	}
+	h1, h2 := blocklistHash(password)
+	var b [1]byte
+	for i := range uint64(k) {
+		bit := (h1 + i*h2) % nBits
+		if _, err := f.ReadAt(b[:], int64(blocklistHeaderSize)+int64(bit/8)); err != nil {
+			return false, err
+		}
+		if b[0]&(1<<(bit%8)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+type blocklistCmd struct {
+	output        string
+	falsePositive float64
+	count         int
+	force         bool
+
+	stdin io.Reader
+}
+
+func (*blocklistCmd) Name() string     { return "blocklist" }
+func (*blocklistCmd) Synopsis() string { return "build a blocklist of breached passwords" }
+func (*blocklistCmd) Usage() string {
+	return `usage: sym blocklist build -o FILE [OPTION]... [LIST]...
+Build a blocklist from password lists with one password per line, or
+from stdin if no lists are provided. sym enc -blocklist FILE refuses
+the passwords in the blocklist. Example:
+  sym blocklist build -o ~/.sym-blocklist breached.txt
+  export SYM_BLOCKLIST=~/.sym-blocklist
+
+The blocklist is a Bloom filter, which is much smaller than the lists,
+but rejects some other passwords too. -fp sets how often that happens.
+The lists are read twice, first to count the passwords, unless -n gives
+the number of passwords. Without -n, stdin is copied to a temporary file
+to read it twice. Lines longer than 64 KiB are skipped.
+
+`
+}
+
+func (c *blocklistCmd) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.output, "o", "", "write the blocklist to `file`")
+	fs.Float64Var(&c.falsePositive, "fp", defaultFalsePositive, "false positive `rate`")
+	fs.IntVar(&c.count, "n", 0, "size the blocklist for `n` passwords instead of counting them")
+	fs.BoolVar(&c.force, "f", false, "overwrite the blocklist if it already exists")
+}
+
+// maxPasswordLine is the length of the longest line that is taken as a
+// password. Longer lines are junk, and are skipped.
+const maxPasswordLine = 64 * 1024
+
+// readPasswords calls fn for each password in r, one per line.
+func readPasswords(r io.Reader, fn func(password string)) error {
+	br := bufio.NewReaderSize(r, maxPasswordLine)
+	for {
+		line, isPrefix, err := br.ReadLine()
+		long := isPrefix
+		for isPrefix && err == nil {
+			_, isPrefix, err = br.ReadLine()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !long && len(line) > 0 {
+			fn(string(line))
+		}
+	}
+}
+
+func (c *blocklistCmd) run(ctx context.Context, args ...string) error {
+	if len(args) == 0 || args[0] != "build" {
+		return usageErr("unknown blocklist command (try sym blocklist build)")
+	}
+	args = args[1:]
+	if c.output == "" {
+		return usageErr("blocklist build requires -o")
+	}
+	if c.falsePositive <= 0 || c.falsePositive >= 1 {
+		return usageErr("-fp must be between 0 and 1")
+	}
+	if c.count < 0 {
+		return usageErr("-n must not be negative")
+	}
+	var tmp *os.File
+	if len(args) == 0 && c.count == 0 {
+		// Stdin is read twice, first to count the passwords.
+		var err error
+		tmp, err = os.CreateTemp("", "sym-blocklist-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, c.stdin); err != nil {
+			return err
+		}
+	}
+	// each calls fn for each password of the lists, or of stdin.
+	each := func(fn func(password string)) error {
+		if tmp != nil {
+			if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			return readPasswords(tmp, fn)
+		}
+		if len(args) == 0 {
+			return readPasswords(c.stdin, fn)
+		}
+		for _, fileName := range args {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			f, err := os.Open(fileName)
+			if err != nil {
+				return err
+			}
+			err = readPasswords(f, fn)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("read %q: %s", fileName, err)
+			}
+		}
+		return nil
+	}
+	n := c.count
+	if n == 0 {
+		if err := each(func(string) { n++ }); err != nil {
+			return err
+		}
+		if n == 0 {
+			return errors.New("no passwords to add to the blocklist")
+		}
+	}
+	nBits, k := blocklistSize(n, c.falsePositive)
+	bits := make([]byte, (nBits+7)/8)
+	added := 0
+	err := each(func(password string) {
+		h1, h2 := blocklistHash(password)
+		for i := range uint64(k) {
+			bit := (h1 + i*h2) % nBits
+			bits[bit/8] |= 1 << (bit % 8)
+		}
+		added++
+	})
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return errors.New("no passwords to add to the blocklist")
+	}
+	if added > n {
+		fmt.Fprintf(os.Stderr, "sym: warning: %d passwords were added, more than -n %d, so more passwords are falsely rejected\n", added, n)
+	}
+	f, err := createOutputFile(c.output, c.force)
+	if err != nil {
+		return err
+	}
+	defer f.abort()
+	header := append([]byte(blocklistMagic), byte(k))
+	header = binary.BigEndian.AppendUint64(header, nBits)
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	if _, err := f.Write(bits); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+func (c *blocklistCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	args := f.Args()
+	if len(args) > 0 && args[0] == "build" {
+		// Options can also come after "build".
+		var err error
+		if args, err = parseAfterCommand(f, args); err != nil {
+			return subcommands.ExitUsageError
+		}
+	}
+	return exitStatus(ctx, c.run(ctx, args...))
+}
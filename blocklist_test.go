@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var listed []string
+	for i := range 1000 {
+		listed = append(listed, fmt.Sprintf("breached%d", i))
+	}
+	listFile := filepath.Join(dir, "list.txt")
+	// Long lines are skipped.
+	long := strings.Repeat("x", maxPasswordLine+1)
+	mustWriteFile(t, listFile, []byte(strings.Join(listed, "\r\n")+"\n\n"+long+"\n"))
+	blocklist := filepath.Join(dir, "blocklist")
+	if err := (&blocklistCmd{output: blocklist, falsePositive: defaultFalsePositive}).run(t.Context(), "build", listFile); err != nil {
+		t.Fatalf("blocklist build failed: %s", err)
+	}
+
+	for _, password := range listed {
+		found, err := blocklisted(blocklist, password)
+		if err != nil {
+			t.Fatalf("blocklisted failed: %s", err)
+		}
+		if !found {
+			t.Errorf("Password %q is not in the blocklist", password)
+		}
+	}
+	for i := range 1000 {
+		password := fmt.Sprintf("not breached %d", i)
+		found, err := blocklisted(blocklist, password)
+		if err != nil {
+			t.Fatalf("blocklisted failed: %s", err)
+		}
+		if found {
+			t.Errorf("Password %q is in the blocklist", password)
+		}
+	}
+	if found, err := blocklisted(blocklist, long); err != nil || found {
+		t.Errorf("blocklisted(long line) = %t, %v, want false", found, err)
+	}
+	if _, err := blocklisted(listFile, "breached0"); err == nil {
+		t.Error("blocklisted succeeded for a file that is not a blocklist, want error")
+	}
+}
+
+func TestEncCmd_Run_Blocklist(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	blocklist := filepath.Join(dir, "blocklist")
+	if err := (&blocklistCmd{stdin: strings.NewReader("asdf\n"), output: blocklist, falsePositive: defaultFalsePositive}).run(t.Context(), "build"); err != nil {
+		t.Fatalf("blocklist build failed: %s", err)
+	}
+	fileName := filepath.Join(dir, "file")
+	mustWriteFile(t, fileName, []byte("test file content"))
+	if err := (&encCmd{password: "asdf", allowWeak: true, blocklist: blocklist}).run(t.Context(), fileName); err == nil {
+		t.Error("enc succeeded with a blocklisted password, want error")
+	}
+	if err := (&encCmd{password: "jkl;", allowWeak: true, blocklist: blocklist}).run(t.Context(), fileName); err != nil {
+		t.Errorf("enc failed with a password that is not blocklisted: %s", err)
+	}
+}
+
+func TestBlocklistCmd_Run(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		stdin   string
+		fp      float64
+		count   int
+		wantErr bool
+	}{{
+		desc:  "Count",
+		stdin: "a\nb\n",
+		fp:    defaultFalsePositive,
+		count: 2,
+	}, {
+		desc:  "MoreThanCount",
+		stdin: "a\nb\nc\n",
+		fp:    defaultFalsePositive,
+		count: 1,
+	}, {
+		desc:    "ZeroFalsePositive",
+		stdin:   "a\n",
+		wantErr: true,
+	}, {
+		desc:    "NegativeCount",
+		stdin:   "a\n",
+		fp:      defaultFalsePositive,
+		count:   -1,
+		wantErr: true,
+	}, {
+		desc:    "Empty",
+		stdin:   "\n\n",
+		fp:      defaultFalsePositive,
+		wantErr: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			blocklist := filepath.Join(t.TempDir(), "blocklist")
+			c := &blocklistCmd{
+				output:        blocklist,
+				falsePositive: tc.fp,
+				count:         tc.count,
+				stdin:         strings.NewReader(tc.stdin),
+			}
+			err := c.run(t.Context(), "build")
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("blocklistCmd.run returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for _, password := range strings.Fields(tc.stdin) {
+				if found, err := blocklisted(blocklist, password); err != nil || !found {
+					t.Errorf("blocklisted(%q) = %t, %v, want true", password, found, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/google/subcommands"
+	"golang.org/x/term"
+)
+
+var errBinaryOutput = errors.New("refusing to write binary data to a terminal (use -force-tty to write it anyway, or redirect the output)")
+
+type catCmd struct {
+	keys     keyFlags
+	forceTTY bool
+
+	stdout io.Writer
+}
+
+func (*catCmd) Name() string     { return "cat" }
+func (*catCmd) Synopsis() string { return "decrypt files to stdout" }
+func (*catCmd) Usage() string {
+	return `usage: sym cat [OPTION]... FILE...
+Decrypt files and write them to stdout one after the other, asking for
+the password only once. Nothing is written to disk. Example:
+  sym cat a.txt.enc b.txt.enc | grep secret
+
+If stdout is a terminal, cat stops before writing data that is not
+text, since it could mess up the terminal, unless -force-tty is given.
+
+` + passwordSourceUsage + "\n"
+}
+
+func (c *catCmd) SetFlags(fs *flag.FlagSet) {
+	c.keys.setFlags(fs, "cat")
+	fs.BoolVar(&c.forceTTY, "force-tty", false, "write binary data even if stdout is a terminal")
+}
+
+// isTerminal reports whether f, a reader or writer, is a terminal.
+func isTerminal(f any) bool {
+	file, ok := f.(*os.File)
+	return ok && term.IsTerminal(int(file.Fd()))
+}
+
+// textWriter passes writes on to w, but fails before writing data that
+// is not UTF-8 text, or that has NUL bytes.
+type textWriter struct {
+	w io.Writer
+	// partial is the start of a character split across writes.
+	partial []byte
+}
+
+func (t *textWriter) Write(p []byte) (int, error) {
+	b := append(t.partial, p...)
+	// Leave an incomplete character at the end for the next write.
+	end := len(b)
+	for i := len(b) - 1; i >= 0 && i > len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	if bytes.IndexByte(b, 0) >= 0 || !utf8.Valid(b[:end]) {
+		return 0, errBinaryOutput
+	}
+	t.partial = append(t.partial[:0], b[end:]...)
+	return t.w.Write(p)
+}
+
+func (c *catCmd) run(ctx context.Context, args ...string) error {
+	if len(args) == 0 {
+		return usageErr("cat requires at least one file")
+	}
+	if err := c.keys.check(); err != nil {
+		return err
+	}
+	key, decrypted, err := c.keys.key(args)
+	if err != nil {
+		return err
+	}
+	w := c.stdout
+	if !c.forceTTY && isTerminal(w) {
+		w = &textWriter{w: w}
+	}
+	for _, fileName := range args {
+		if err := c.cat(ctx, w, fileName, key); err != nil {
+			return err
+		}
+		decrypted()
+	}
+	return nil
+}
+
+func (c *catCmd) cat(ctx context.Context, w io.Writer, fileName string, key keyFunc) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rr := &readRecorder{r: newKeyedDecryptingReader(ctx, f, key)}
+	if _, err := io.Copy(w, rr); err != nil {
+		if rr.err != nil && ctx.Err() == nil {
+			c.keys.pwSource.reportFailure(rr.err)
+		}
+		if errors.Is(err, errBinaryOutput) {
+			return fmt.Errorf("%q: %w", fileName, err)
+		}
+		return fmt.Errorf("decrypt %q: %s", fileName, err)
+	}
+	return nil
+}
+
+func (c *catCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return exitStatus(ctx, c.run(ctx, f.Args()...))
+}
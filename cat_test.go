@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestTextWriter(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		writes  []string
+		wantErr bool
+	}{
+		{desc: "Text", writes: []string{"hello\n", "world\n"}},
+		{desc: "UTF8", writes: []string{"café \U0001f511\n"}},
+		{desc: "SplitCharacter", writes: []string{"caf\xc3", "\xa9\n"}},
+		{desc: "SplitEmoji", writes: []string{"\xf0\x9f", "\x94", "\x91"}},
+		{desc: "NUL", writes: []string{"text", "\x00"}, wantErr: true},
+		{desc: "Invalid", writes: []string{"\xff\xfe"}, wantErr: true},
+		{desc: "BadContinuation", writes: []string{"caf\xc3", "e"}, wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			w := &textWriter{w: &out}
+			var err error
+			for _, s := range tc.writes {
+				if _, err = w.Write([]byte(s)); err != nil {
+					break
+				}
+			}
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Write returned error %v, want error? %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCatCmd_Run(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	var files []string
+	for _, content := range []string{"first\n", "second\n"} {
+		fileName := filepath.Join(dir, content[:len(content)-1])
+		mustWriteFile(t, fileName, []byte(content))
+		if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey("asdf")); err != nil {
+			t.Fatalf("encryptFile failed: %s", err)
+		}
+		files = append(files, fileName+".enc")
+	}
+
+	for _, tc := range []struct {
+		desc     string
+		password string
+		files    []string
+		want     string
+		wantErr  bool
+	}{{
+		desc:     "Files",
+		password: "asdf",
+		files:    files,
+		want:     "first\nsecond\n",
+	}, {
+		desc:     "Order",
+		password: "asdf",
+		files:    []string{files[1], files[0]},
+		want:     "second\nfirst\n",
+	}, {
+		desc:     "WrongPassword",
+		password: "wrong",
+		files:    files,
+		wantErr:  true,
+	}, {
+		desc:     "Missing",
+		password: "asdf",
+		files:    []string{files[0], filepath.Join(dir, "missing.enc")},
+		want:     "first\n",
+		wantErr:  true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			prompts := 0
+			var stdout bytes.Buffer
+			c := &catCmd{
+				keys: keyFlags{passwordIn: func(string) (string, error) {
+					prompts++
+					return tc.password, nil
+				}},
+				stdout: &stdout,
+			}
+			err := c.run(t.Context(), tc.files...)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("catCmd.run returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if prompts != 1 {
+				t.Errorf("cat prompted for the password %d times, want 1", prompts)
+			}
+			if got := stdout.String(); got != tc.want {
+				t.Errorf("cat wrote %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCatCmd_Cat_Binary(t *testing.T) {
+	t.Parallel()
+
+	fileName := filepath.Join(t.TempDir(), "file")
+	mustWriteFile(t, fileName, []byte("binary\x00data"))
+	if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey("asdf")); err != nil {
+		t.Fatalf("encryptFile failed: %s", err)
+	}
+	var stdout bytes.Buffer
+	err := (&catCmd{}).cat(t.Context(), &textWriter{w: &stdout}, fileName+".enc", passwordKey("asdf"))
+	if !errors.Is(err, errBinaryOutput) {
+		t.Errorf("cat returned error %v, want %v", err, errBinaryOutput)
+	}
+	if stdout.Len() > 0 {
+		t.Errorf("cat wrote %q to the terminal", stdout.String())
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+// Passphrases generated by enc -g end with a checksum word, like BIP39
+// mnemonics, so that typos are caught, and can be corrected, before
+// the password is hashed. The checksum word is chosen by the SHA-256
+// hash of the indexes of the other words in the word list.
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"roseh.moe/pkg/wordlist"
+)
+
+// maxTypoDistance is the largest edit distance between a word and a
+// word of the word list that is taken for a typo.
+const maxTypoDistance = 2
+
+// checksumIndex returns the index of the checksum word for the words
+// with the given indexes, in a word list of n words.
+func checksumIndex(indexes []int, n int) int {
+	h := sha256.New()
+	for _, i := range indexes {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	}
+	return int(binary.BigEndian.Uint64(h.Sum(nil)) % uint64(n))
+}
+
+// wordIndexes maps the words of wordlist.Words to their indexes.
+var wordIndexes = sync.OnceValue(func() map[string]int {
+	m := make(map[string]int, len(wordlist.Words))
+	for i := range len(wordlist.Words) {
+		m[wordlist.Words[i]] = i
+	}
+	return m
+})
+
+// wordBits is the number of bits encoded by each word of
+// wordlist.Words.
+const wordBits = 13
+
+// bytesToWords splits b into wordBits bit numbers, the indexes of the
+// words that encode b. The last word is padded with zero bits.
+func bytesToWords(b []byte) []int {
+	indexes := make([]int, (len(b)*8+wordBits-1)/wordBits)
+	for bit := range len(b) * 8 {
+		if b[bit/8]&(0x80>>(bit%8)) != 0 {
+			indexes[bit/wordBits] |= 1 << (wordBits - 1 - bit%wordBits)
+		}
+	}
+	return indexes
+}
+
+// wordsToBytes returns the first n bytes encoded by the words with the
+// given indexes. It is the inverse of bytesToWords.
+func wordsToBytes(indexes []int, n int) []byte {
+	b := make([]byte, n)
+	for bit := range n * 8 {
+		if indexes[bit/wordBits]&(1<<(wordBits-1-bit%wordBits)) != 0 {
+			b[bit/8] |= 0x80 >> (bit % 8)
+		}
+	}
+	return b
+}
+
+// editDistance returns the number of insertions, deletions,
+// substitutions and transpositions of adjacent characters it takes to
+// turn a into b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// d[i][j] is the distance between s[:i] and t[:j].
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+// checkPassphrase warns about a typo in passwords that look like they
+// were generated by enc -g, before the password is hashed. The password
+// is still used, since a password picked by hand can look like a
+// generated one with a typo.
+func checkPassphrase(password string) {
+	if suggestion := passphraseSuggestion(password); suggestion != "" {
+		fmt.Fprintf(os.Stderr, "sym: warning: %s\n", suggestion)
+	}
+}
+
+// passphraseSuggestion checks the checksum of passwords that look like
+// they were generated by enc -g. If the checksum doesn't match, but
+// changing one word to a similar word of the word list fixes it, a
+// suggestion of the fix is returned. Otherwise it returns "".
+func passphraseSuggestion(password string) string {
+	words := strings.Fields(password)
+	if len(words) != defaultWords+1 {
+		return ""
+	}
+	indexes := make([]int, len(words))
+	unknown := -1
+	for i, word := range words {
+		index, ok := wordIndexes()[word]
+		if !ok {
+			if unknown >= 0 {
+				return ""
+			}
+			unknown = i
+		}
+		indexes[i] = index
+	}
+	valid := func(indexes []int) bool {
+		return checksumIndex(indexes[:defaultWords], len(wordlist.Words)) == indexes[defaultWords]
+	}
+	if unknown < 0 && valid(indexes) {
+		return ""
+	}
+	if i, index, ok := fixTypo(words, indexes, unknown, valid); ok {
+		return fmt.Sprintf("the passphrase checksum does not match, did you mean %q instead of %q (word %d)?", wordlist.Words[index], words[i], i+1)
+	}
+	return ""
+}
+
+// fixTypo looks for a word of the word list that, put in place of one
+// of the typed words, makes valid return true. indexes holds the
+// indexes of the words, and unknown is the position of the word that is
+// not in the word list, or -1. It returns the position and the index
+// of the fix.
+func fixTypo(words []string, indexes []int, unknown int, valid func([]int) bool) (i, index int, ok bool) {
+	// A word that is not in the word list is the typo. Otherwise, the
+	// typo turned one word into another, and it could be any of them.
+	positions := []int{unknown}
+	if unknown < 0 {
+		positions = make([]int, len(words))
+		for i := range positions {
+			positions[i] = i
+		}
+	}
+	// candidates[d] holds the positions and indexes of the words at
+	// edit distance d from the typed words.
+	candidates := make([][][2]int, maxTypoDistance+1)
+	for _, i := range positions {
+		for index := range len(wordlist.Words) {
+			if d := editDistance(words[i], wordlist.Words[index]); d > 0 && d <= maxTypoDistance {
+				candidates[d] = append(candidates[d], [2]int{i, index})
+			}
+		}
+	}
+	fixed := append([]int(nil), indexes...)
+	for _, cs := range candidates {
+		for _, c := range cs {
+			i, index := c[0], c[1]
+			fixed[i] = index
+			if valid(fixed) {
+				return i, index, true
+			}
+			fixed[i] = indexes[i]
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"roseh.moe/pkg/wordlist"
+)
+
+// typo adds a letter to word, so that it is not in the word list and
+// the fix is always the same word.
+func typo(word string) string {
+	for _, c := range "abcdefghijklmnopqrstuvwxyz" {
+		if _, ok := wordIndexes()[word+string(c)]; !ok {
+			return word + string(c)
+		}
+	}
+	panic("no typo for " + word)
+}
+
+// mistype returns the words joined by spaces, with a typo in word i.
+func mistype(words []string, i int) string {
+	w := slices.Clone(words)
+	w[i] = typo(w[i])
+	return strings.Join(w, " ")
+}
+
+func TestEditDistance(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"word", "word", 0},
+		{"word", "ward", 1},
+		{"word", "wrod", 1},
+		{"word", "words", 1},
+		{"word", "wd", 2},
+		{"kitten", "sitting", 3},
+	} {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestPassphraseSuggestion(t *testing.T) {
+	t.Parallel()
+
+	// A fixed passphrase, so that the suggested fixes don't depend on
+	// chance.
+	indexes := []int{100, 1200, 2300, 3400, 4500, 5600, 6700, 7800, 800, 1900}
+	var words []string
+	for _, i := range append(indexes, checksumIndex(indexes, len(wordlist.Words))) {
+		words = append(words, wordlist.Words[i])
+	}
+	passphrase := strings.Join(words, " ")
+	for _, tc := range []struct {
+		desc     string
+		password string
+		// wantFix is the word that should be suggested, if any.
+		wantFix string
+	}{{
+		desc:     "Valid",
+		password: passphrase,
+	}, {
+		desc:     "ExtraSpaces",
+		password: "  " + strings.ReplaceAll(passphrase, " ", "   "),
+	}, {
+		desc:     "Typo",
+		password: mistype(words, 3),
+		wantFix:  words[3],
+	}, {
+		desc:     "ChecksumTypo",
+		password: mistype(words, defaultWords),
+		wantFix:  words[defaultWords],
+	}, {
+		desc:     "NotGenerated",
+		password: "correct horse battery staple",
+	}, {
+		desc:     "WithoutChecksum",
+		password: strings.Join(words[:defaultWords], " "),
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got := passphraseSuggestion(tc.password)
+			if tc.wantFix == "" {
+				if got != "" {
+					t.Errorf("passphraseSuggestion(%q) = %q, want no suggestion", tc.password, got)
+				}
+				return
+			}
+			if !strings.Contains(got, fmt.Sprintf("%q instead", tc.wantFix)) {
+				t.Errorf("passphraseSuggestion(%q) = %q, want a suggestion of %q", tc.password, got, tc.wantFix)
+			}
+		})
+	}
+}
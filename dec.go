@@ -1,24 +1,32 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/google/subcommands"
 )
 
 type decCmd struct {
-	password string
+	keys     keyFlags
 	force    bool
+	patterns stringsFlag
+	mirror   bool
+	remove   bool
+	output   string
+	dir      string
+	suffix   string
+	useName  bool
 
-	passwordIn func() (string, error)
-	stdin      io.Reader
-	stdout     io.Writer
+	stdin  io.Reader
+	stdout io.Writer
 }
 
 func (*decCmd) Name() string     { return "dec" }
@@ -27,103 +35,319 @@ func (*decCmd) Usage() string {
 	return `usage: sym dec [OPTION]... [FILE]...
 Decrypt files, or stdin if no files are provided.
 
--p is required when reading from stdin.
-
 For example,
   sym dec my-encrypted-file.txt.enc
 would decrypt my-encrypted-file.txt.enc and write the result to
 my-encrypted-file.txt. If a filename does not end with .enc, the name
-will be appended with a .dec extension.
+will be appended with a .dec extension. Use -suffix to strip a
+different extension, -o to choose the output file, or -C to write the
+output files into another directory. With -N, the original file name
+stored by sym enc is used instead, if the file has one.
+
+Directory archives created with sym enc -r are extracted into a new
+directory named after the file, without the .tar.enc extension. When
+reading from stdin, the archive is written to stdout as a tar stream.
+Use -x to extract only some of the entries, for example
+  sym dec -x 'photos/2024/*' -x notes.txt home.tar.enc
+Patterns use the syntax of path.Match, and matching a directory
+extracts everything inside it.
+
+With -mirror, dec takes a tree encrypted with sym enc -mirror and a
+destination directory, and decrypts the whole tree into the destination.
+
+With -rm, each encrypted file is removed once it has been decrypted and
+its content authenticated. -rm refuses symlinks, since removing one
+would leave the file it points to.
+
+Files encrypted with -keyfile need the same keyfiles, given with
+-keyfile in any order. If a file was encrypted with only keyfiles, dec
+doesn't ask for a password.
+
+Passwords generated by sym enc -g end with a checksum word. dec checks
+it before hashing the password, and warns with a suggested fix if a
+word looks mistyped.
 
-`
+Files encrypted with sym enc -shares k/n need k of the shares, given
+with -share as words or with -share-file, in any order. No password is
+asked for.
+
+` + passwordSourceUsage + "\n"
 }
 
 func (c *decCmd) SetFlags(fs *flag.FlagSet) {
-	fs.StringVar(&c.password, "p", "", "use the specified password; if not provided, dec will prompt for a password")
+	c.keys.setFlags(fs, "dec")
 	fs.BoolVar(&c.force, "f", false, "overwrite output files even if they already exist")
+	fs.Var(&c.patterns, "x", "extract only archive entries matching `pattern` (may be repeated)")
+	fs.BoolVar(&c.mirror, "mirror", false, "decrypt the encrypted tree in the first directory into the second")
+	fs.BoolVar(&c.remove, "rm", false, "remove the encrypted files after decrypting them")
+	fs.StringVar(&c.output, "o", "", "write the output to `file` (only with a single input)")
+	fs.StringVar(&c.dir, "C", "", "write the output files into `dir`")
+	fs.StringVar(&c.suffix, "suffix", ".enc", "`suffix` removed from the names of the input files")
+	fs.BoolVar(&c.useName, "N", false, "use the original file name stored in the encrypted file")
 }
 
-func (c *decCmd) decrypt(w io.Writer, r io.Reader, password string) error {
-	_, err := io.Copy(w, newDecryptingReader(r, password))
+func (c *decCmd) decrypt(ctx context.Context, w io.Writer, r io.Reader, key keyFunc) error {
+	rr := &readRecorder{r: newKeyedDecryptingReader(ctx, r, key)}
+	_, err := io.Copy(w, rr)
+	c.decryptFailed(ctx, rr.err)
 	return err
 }
 
-func (c *decCmd) decryptFile(fileName string, password string) (err error) {
-	var outFileName string
-	if name, ok := strings.CutSuffix(fileName, ".enc"); ok {
-		outFileName = name
+// readRecorder reads from the decrypting reader r, or from ra, and
+// records the last error other than io.EOF. This tells errors from
+// decrypting apart from others, like errors writing the output.
+type readRecorder struct {
+	r   io.Reader
+	ra  io.ReaderAt
+	err error
+}
+
+func (r *readRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.record(err)
+	return n, err
+}
+
+func (r *readRecorder) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ra.ReadAt(p, off)
+	r.record(err)
+	return n, err
+}
+
+func (r *readRecorder) record(err error) {
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+}
+
+// decryptFailed tells the password helper that decryption failed, if
+// err is not nil, since the password may be wrong. It must only be
+// called with errors from decrypting, not with errors like an existing
+// output file. It returns err.
+func (c *decCmd) decryptFailed(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() == nil {
+		c.keys.pwSource.reportFailure(err)
+	}
+	return err
+}
+
+// outputName returns the name of the file that fileName is decrypted
+// to. storedName is the original name stored in the file, if any.
+func (c *decCmd) outputName(fileName, storedName string) (string, error) {
+	if c.output != "" {
+		return c.output, nil
+	}
+	dir, base := filepath.Split(fileName)
+	if c.dir != "" {
+		dir = c.dir
+	}
+	if c.useName && storedName != "" {
+		if storedName == "." || storedName == ".." || strings.ContainsAny(storedName, `/\`) {
+			return "", fmt.Errorf("unsafe stored file name %q", storedName)
+		}
+		base = storedName
+	} else if name, ok := strings.CutSuffix(base, cmp.Or(c.suffix, ".enc")); ok && name != "" {
+		base = name
 	} else {
-		outFileName = fileName + ".dec"
+		base += ".dec"
+	}
+	return filepath.Join(dir, base), nil
+}
+
+func (c *decCmd) decryptFile(ctx context.Context, fileName string, key keyFunc) (err error) {
+	if c.remove {
+		if err := checkRemovable(fileName); err != nil {
+			return err
+		}
 	}
 	fIn, err := os.Open(fileName)
 	if err != nil {
 		return err
 	}
 	defer fIn.Close()
-	fileOpts := os.O_CREATE | os.O_WRONLY
-	if c.force {
-		fileOpts |= os.O_TRUNC
-	} else {
-		fileOpts |= os.O_EXCL
+	reader := newKeyedDecryptingReader(ctx, fIn, key)
+	header, err := reader.readHeader()
+	if err != nil {
+		return fmt.Errorf("decrypt %q: %s", fileName, c.decryptFailed(ctx, err))
 	}
-	fOut, err := os.OpenFile(outFileName, fileOpts, 0644)
+	outFileName, err := c.outputName(fileName, reader.name)
 	if err != nil {
-		if errors.Is(err, os.ErrExist) {
-			return fmt.Errorf("output file %q exists (use -f to overwrite)", outFileName)
+		return fmt.Errorf("decrypt %q: %s", fileName, err)
+	}
+	if header.archive {
+		if c.output == "" {
+			outFileName = strings.TrimSuffix(outFileName, ".tar")
 		}
+		if err := checkNotInput(fIn, outFileName); err != nil {
+			return err
+		}
+		if err := c.extract(ctx, outFileName, fIn, reader); err != nil {
+			return fmt.Errorf("decrypt %q: %s", fileName, err)
+		}
+		return c.removeInput(fIn)
+	}
+	if len(c.patterns) > 0 {
+		return fmt.Errorf("-x was given, but %q is not a directory archive", fileName)
+	}
+	if err := checkNotInput(fIn, outFileName); err != nil {
 		return err
 	}
+	fOut, err := createOutputFile(outFileName, c.force)
+	if err != nil {
+		return err
+	}
+	defer fOut.abort()
+	rr := &readRecorder{r: reader}
+	if _, err := io.Copy(fOut, rr); err != nil {
+		c.decryptFailed(ctx, rr.err)
+		return fmt.Errorf("decrypt %q: %s", fileName, err)
+	}
+	if err := fOut.commit(); err != nil {
+		return err
+	}
+	return c.removeInput(fIn)
+}
+
+// removeInput removes the encrypted file f after it was decrypted, if
+// -rm was given. Reading to the end of the file authenticated it, so
+// the output is known to be complete.
+func (c *decCmd) removeInput(f *os.File) error {
+	if !c.remove {
+		return nil
+	}
+	f.Close()
+	return removeFile(f.Name(), false)
+}
+
+// extract extracts the archive being decrypted by r into dir. When only
+// some entries are selected and the archive has an index, f is used to
+// seek directly to them.
+func (c *decCmd) extract(ctx context.Context, dir string, f *os.File, r *decryptingReader) (err error) {
+	var matcher *archiveMatcher
+	if len(c.patterns) > 0 {
+		if matcher, err = newArchiveMatcher(c.patterns); err != nil {
+			return err
+		}
+	}
+	switch mkdirErr := os.Mkdir(dir, 0755); {
+	case mkdirErr == nil:
+		defer func() {
+			if err != nil {
+				os.RemoveAll(dir)
+			}
+		}()
+	case !errors.Is(mkdirErr, os.ErrExist):
+		return mkdirErr
+	case !c.force:
+		return fmt.Errorf("output directory %q exists (use -f to overwrite)", dir)
+	}
+	// Only errors from decrypting are reported, not errors like an
+	// existing output file or a pattern that matches nothing.
+	rr := &readRecorder{r: r}
 	defer func() {
-		fOut.Close()
 		if err != nil {
-			os.Remove(fOut.Name())
+			c.decryptFailed(ctx, rr.err)
 		}
 	}()
-	if err := c.decrypt(fOut, fIn, password); err != nil {
-		return fmt.Errorf("decrypt %q: %s", fileName, err)
+	if matcher != nil {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			ra, err := newSegmentReaderAt(f, fi.Size(), r)
+			if err != nil {
+				return err
+			}
+			rr.ra = ra
+			index, err := readIndex(rr, ra.Size())
+			if err != nil {
+				return err
+			}
+			if index != nil {
+				return extractIndexed(rr, ra.Size(), index, dir, c.force, matcher)
+			}
+		}
 	}
-	return fOut.Close()
+	return extractArchive(rr, dir, c.force, matcher)
 }
 
-func (c *decCmd) readPassword() (string, error) {
-	fmt.Fprint(os.Stderr, "Enter password: ")
-	pw, err := c.passwordIn()
-	fmt.Fprintln(os.Stderr)
-	return pw, err
+// decryptStdin decrypts stdin to the file given with -o.
+func (c *decCmd) decryptStdin(ctx context.Context, key keyFunc) error {
+	fOut, err := createOutputFile(c.output, c.force)
+	if err != nil {
+		return err
+	}
+	defer fOut.abort()
+	if err := c.decrypt(ctx, fOut, c.stdin, key); err != nil {
+		return err
+	}
+	return fOut.commit()
 }
 
-func (c *decCmd) run(args ...string) error {
-	if len(args) == 0 && c.password == "" {
-		return usageErr("-p is required when reading from stdin")
+func (c *decCmd) run(ctx context.Context, args ...string) error {
+	if err := c.keys.check(); err != nil {
+		return err
 	}
-	var password string
-	if c.password != "" {
-		password = c.password
-	} else {
-		var err error
-		password, err = c.readPassword()
-		if err != nil {
+	if len(args) == 0 && c.keys.pwSource.hasFD && c.keys.pwSource.fd == 0 {
+		return usageErr("-password-fd 0 cannot be used when reading from stdin")
+	}
+	if len(args) == 0 && len(c.patterns) > 0 {
+		return usageErr("-x cannot be used when reading from stdin")
+	}
+	if _, err := newArchiveMatcher(c.patterns); err != nil {
+		return err
+	}
+	if c.mirror && len(args) != 2 {
+		return usageErr("-mirror requires a source and a destination directory")
+	}
+	if c.output != "" && len(args) > 1 {
+		return usageErr("-o cannot be used with more than one input")
+	}
+	if c.output != "" && c.dir != "" {
+		return usageErr("-o and -C cannot be used together")
+	}
+	if c.mirror && (c.output != "" || c.dir != "") {
+		return usageErr("-o and -C cannot be used with -mirror")
+	}
+	if c.remove && (len(args) == 0 || c.mirror || len(c.patterns) > 0) {
+		return usageErr("-rm cannot be used with -x, -mirror or when reading from stdin")
+	}
+	key, decrypted, err := c.keys.key(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 && c.output != "" {
+		if err := c.decryptStdin(ctx, key); err != nil {
 			return err
 		}
+		decrypted()
+		return nil
 	}
 	if len(args) == 0 {
-		return c.decrypt(c.stdout, c.stdin, password)
+		if err := c.decrypt(ctx, c.stdout, c.stdin, key); err != nil {
+			return err
+		}
+		decrypted()
+		return nil
+	}
+	if c.mirror {
+		m, err := openMirror(ctx, args[0], key, false)
+		if err != nil {
+			if _, statErr := os.Stat(filepath.Join(args[0], mirrorKeyFile)); statErr == nil {
+				// The master key could not be decrypted.
+				c.decryptFailed(ctx, err)
+			}
+			return err
+		}
+		decrypted()
+		return m.decryptTree(ctx, args[0], args[1], c.force)
 	}
 	for _, fileName := range args {
-		if err := c.decryptFile(fileName, password); err != nil {
+		if err := c.decryptFile(ctx, fileName, key); err != nil {
 			return err
 		}
+		decrypted()
 	}
 	return nil
 }
 
 func (c *decCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
-	if err := c.run(f.Args()...); err != nil {
-		fmt.Fprintf(os.Stderr, "sym: %s\n", err)
-		if errors.Is(err, errUsage) {
-			return subcommands.ExitUsageError
-		}
-		return subcommands.ExitFailure
-	}
-	return subcommands.ExitSuccess
+	return exitStatus(ctx, c.run(ctx, f.Args()...))
 }
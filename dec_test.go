@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"errors"
+	"os"
 	"path/filepath"
 	"slices"
 	"strings"
@@ -31,10 +32,10 @@ func TestDecryptFile_Force(t *testing.T) {
 			const password = "asdf"
 			fileName := filepath.Join(t.TempDir(), "file")
 			mustWriteFile(t, fileName, []byte("test file content"))
-			if err := (&encCmd{}).encryptFile(fileName, password); err != nil {
+			if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
 				t.Fatalf("Failed to encrypt file: %s", err)
 			}
-			err := (&decCmd{force: tc.force}).decryptFile(fileName+".enc", password)
+			err := (&decCmd{force: tc.force}).decryptFile(t.Context(), fileName+".enc", passwordKey(password))
 			if gotErr := err != nil; gotErr != tc.wantErr {
 				t.Errorf("decryptFile(force=%t) returned returned error %v when output file exists, want error? %t", tc.force, err, tc.wantErr)
 			}
@@ -68,7 +69,7 @@ func TestDecrypt_BadFileFormat(t *testing.T) {
 
 			fileName := filepath.Join(t.TempDir(), "file")
 			mustWriteFile(t, fileName, tc.fileContent)
-			err := (&decCmd{}).decryptFile(fileName, "asdf")
+			err := (&decCmd{}).decryptFile(t.Context(), fileName, passwordKey("asdf"))
 			if err == nil {
 				t.Errorf("DecryptFile succeeded for incorrect file format, want error")
 			}
@@ -83,11 +84,11 @@ func TestDecryptFile_WeirdName(t *testing.T) {
 	fileContent := []byte("file content")
 	fileName := filepath.Join(t.TempDir(), "file")
 	mustWriteFile(t, fileName, fileContent)
-	if err := (&encCmd{}).encryptFile(fileName, password); err != nil {
+	if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
 		t.Fatalf("EncryptFile failed: %s", err)
 	}
 	mustRename(t, fileName+".enc", fileName+".encrypted")
-	if err := (&decCmd{}).decryptFile(fileName+".encrypted", password); err != nil {
+	if err := (&decCmd{}).decryptFile(t.Context(), fileName+".encrypted", passwordKey(password)); err != nil {
 		t.Fatalf("DecryptFile failed: %s", err)
 	}
 	gotContents := mustReadFile(t, fileName+".encrypted.dec")
@@ -96,10 +97,78 @@ func TestDecryptFile_WeirdName(t *testing.T) {
 	}
 }
 
+func TestDecryptFile_OutputName(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		input   string
+		output  string
+		dir     string
+		suffix  string
+		useName bool
+		want    string
+	}{{
+		desc:  "Default",
+		input: "in/file.enc",
+		want:  "in/file",
+	}, {
+		desc:   "Suffix",
+		input:  "in/file.sym",
+		suffix: ".sym",
+		want:   "in/file",
+	}, {
+		desc:  "Dir",
+		input: "in/file.enc",
+		dir:   "out",
+		want:  "out/file",
+	}, {
+		desc:   "Output",
+		input:  "in/file.enc",
+		output: "out/decrypted",
+		want:   "out/decrypted",
+	}, {
+		desc:    "StoredName",
+		input:   "in/renamed.enc",
+		useName: true,
+		want:    "in/original",
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			const password = "asdf"
+			fileContent := []byte("test file content")
+			dir := t.TempDir()
+			mustMkdir(t, filepath.Join(dir, "in"))
+			mustMkdir(t, filepath.Join(dir, "out"))
+			fileName := filepath.Join(dir, "in", "original")
+			mustWriteFile(t, fileName, fileContent)
+			if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
+				t.Fatalf("encryptFile failed: %s", err)
+			}
+			mustRemove(t, fileName)
+			mustRename(t, fileName+".enc", filepath.Join(dir, tc.input))
+			c := &decCmd{suffix: tc.suffix, useName: tc.useName}
+			if tc.output != "" {
+				c.output = filepath.Join(dir, tc.output)
+			}
+			if tc.dir != "" {
+				c.dir = filepath.Join(dir, tc.dir)
+			}
+			if err := c.decryptFile(t.Context(), filepath.Join(dir, tc.input), passwordKey(password)); err != nil {
+				t.Fatalf("decryptFile failed: %s", err)
+			}
+			if got := mustReadFile(t, filepath.Join(dir, tc.want)); !bytes.Equal(got, fileContent) {
+				t.Errorf("Decrypted file has contents %q, want %q", got, fileContent)
+			}
+		})
+	}
+}
+
 func TestDecryptFile_NotFound(t *testing.T) {
 	t.Parallel()
 
-	err := (&decCmd{}).decryptFile("my-nonexistent-file.txt", "asdf")
+	err := (&decCmd{}).decryptFile(t.Context(), "my-nonexistent-file.txt", passwordKey("asdf"))
 	if err == nil {
 		t.Fatal("decryptFile succeeded for nonexistent file, want error")
 	}
@@ -112,7 +181,7 @@ func TestDecryptFile_NoPermission(t *testing.T) {
 	mustWriteFile(t, fileName, []byte("test file content"))
 	mustWriteFile(t, strings.TrimSuffix(fileName, ".enc"), nil)
 	mustChmod(t, strings.TrimSuffix(fileName, ".enc"), 0400)
-	err := (&decCmd{force: true}).decryptFile(fileName, "asdf")
+	err := (&decCmd{force: true}).decryptFile(t.Context(), fileName, passwordKey("asdf"))
 	if err == nil {
 		t.Fatal("decryptFile succeeded for unwritable file, want error")
 	}
@@ -125,11 +194,11 @@ func TestDecCmd_Run(t *testing.T) {
 	fileContent := []byte("test file content")
 	fileName := filepath.Join(t.TempDir(), "file")
 	mustWriteFile(t, fileName, fileContent)
-	if err := (&encCmd{}).encryptFile(fileName, password); err != nil {
+	if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
 		t.Errorf("EncryptFile failed: %s", err)
 	}
 	mustRemove(t, fileName)
-	err := (&decCmd{password: password}).run(fileName + ".enc")
+	err := (&decCmd{keys: keyFlags{password: password}}).run(t.Context(), fileName+".enc")
 	if err != nil {
 		t.Errorf("decCmd.run failed: %s", err)
 	}
@@ -139,19 +208,98 @@ func TestDecCmd_Run(t *testing.T) {
 	}
 }
 
+func TestDecCmd_Run_Remove(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	fileContent := []byte("test file content")
+	fileName := filepath.Join(t.TempDir(), "file")
+	mustWriteFile(t, fileName, fileContent)
+	if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
+		t.Fatalf("EncryptFile failed: %s", err)
+	}
+	mustRemove(t, fileName)
+	if err := (&decCmd{keys: keyFlags{password: password}, remove: true}).run(t.Context(), fileName+".enc"); err != nil {
+		t.Fatalf("dec -rm failed: %s", err)
+	}
+	if got := mustReadFile(t, fileName); !bytes.Equal(got, fileContent) {
+		t.Errorf("Decrypted file has contents %q, want %q", got, fileContent)
+	}
+	if _, err := os.Stat(fileName + ".enc"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("dec -rm left the encrypted file behind: %v", err)
+	}
+}
+
+func TestDecCmd_Run_RemoveRefused(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		useName bool
+		output  bool
+		symlink bool
+	}{{
+		desc:   "OutputIsInput",
+		output: true,
+	}, {
+		desc:    "StoredNameIsInput",
+		useName: true,
+	}, {
+		desc:    "Symlink",
+		symlink: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			const password = "asdf"
+			dir := t.TempDir()
+			fileName := filepath.Join(dir, "file")
+			mustWriteFile(t, fileName, []byte("test file content"))
+			if err := (&encCmd{output: filepath.Join(dir, "file.enc")}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
+				t.Fatalf("encryptFile failed: %s", err)
+			}
+			// With -N, the stored name "file" is the input itself.
+			input := fileName + ".enc"
+			if tc.useName {
+				mustRemove(t, fileName)
+				mustRename(t, input, fileName)
+				input = fileName
+			}
+			c := &decCmd{keys: keyFlags{password: password}, remove: true, force: true, useName: tc.useName}
+			if tc.output {
+				c.output = input
+			}
+			if tc.symlink {
+				link := filepath.Join(dir, "link.enc")
+				if err := os.Symlink(input, link); err != nil {
+					t.Skipf("Failed to create symlink: %s", err)
+				}
+				input = link
+			}
+			encrypted := mustReadFile(t, input)
+			if err := c.run(t.Context(), input); err == nil {
+				t.Errorf("dec -rm of %s succeeded, want error", tc.desc)
+			}
+			if got := mustReadFile(t, input); !bytes.Equal(got, encrypted) {
+				t.Errorf("Encrypted input was modified")
+			}
+		})
+	}
+}
+
 func TestDecCmd_Run_UsageError(t *testing.T) {
 	t.Parallel()
 
-	err := (&decCmd{}).run()
+	err := (&decCmd{patterns: []string{"file"}}).run(t.Context())
 	if err == nil {
-		t.Errorf("Run without -p when reading from stdin, want error")
+		t.Errorf("Run with -x when reading from stdin succeeded, want error")
 	}
 }
 
 func TestDecCmd_Run_NotFound(t *testing.T) {
 	t.Parallel()
 
-	err := (&decCmd{password: "asdf"}).run("my-nonexistent-file-name.txt")
+	err := (&decCmd{keys: keyFlags{password: "asdf"}}).run(t.Context(), "my-nonexistent-file-name.txt")
 	if err == nil {
 		t.Errorf("run succeeded with nonexistent file, want error")
 	}
@@ -163,15 +311,15 @@ func TestDecCmd_Run_Stdin(t *testing.T) {
 	const password = "asdf"
 	content := []byte("test contents")
 	encrypted := new(bytes.Buffer)
-	if err := (&encCmd{}).encrypt(encrypted, bytes.NewReader(content), password); err != nil {
+	if err := (&encCmd{}).encrypt(t.Context(), encrypted, bytes.NewReader(content), passwordKey(password)); err != nil {
 		t.Fatalf("Failed to encrypt: %s", err)
 	}
 	gotContentBuf := new(bytes.Buffer)
 	if err := (&decCmd{
-		password: password,
-		stdin:    bytes.NewReader(encrypted.Bytes()),
-		stdout:   gotContentBuf,
-	}).run(); err != nil {
+		keys:   keyFlags{password: password},
+		stdin:  bytes.NewReader(encrypted.Bytes()),
+		stdout: gotContentBuf,
+	}).run(t.Context()); err != nil {
 		t.Fatalf("run failed: %s", err)
 	}
 	gotContent := gotContentBuf.Bytes()
@@ -201,16 +349,16 @@ func TestDecCmd_Run_ReadPassword(t *testing.T) {
 
 			fileName := filepath.Join(t.TempDir(), "file")
 			mustWriteFile(t, fileName, []byte("test file content"))
-			if err := (&encCmd{}).encryptFile(fileName, password); err != nil {
+			if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
 				t.Errorf("EncryptFile failed: %s", err)
 			}
 			mustRemove(t, fileName)
 
-			err := (&decCmd{
-				passwordIn: func() (string, error) {
+			err := (&decCmd{keys: keyFlags{
+				passwordIn: func(string) (string, error) {
 					return password, tc.err
 				},
-			}).run(fileName + ".enc")
+			}}).run(t.Context(), fileName+".enc")
 			if gotErr := err != nil; gotErr != tc.wantErr {
 				t.Errorf("decCmd.run returned error %v reading password from stdin, want error? %t", err, tc.wantErr)
 			}
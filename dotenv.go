@@ -0,0 +1,233 @@
+package main
+
+// Dotenv files hold environment variables, one NAME=value assignment
+// per line:
+//
+//	# Comments start with #.
+//	export DB_USER=admin        # export is optional
+//	DB_PASSWORD='literal $value'
+//	GREETING="line one\nline two"
+//
+// Single quoted values are taken literally. Double quoted values can
+// have the escapes \n, \r, \t, \\, \" and \$, and both can span several
+// lines. Unquoted values end at the end of the line or at a # preceded
+// by a space. Variables are not expanded.
+
+import (
+	"fmt"
+	"strings"
+)
+
+type envVar struct {
+	name, value string
+}
+
+type dotenvParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *dotenvParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *dotenvParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *dotenvParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *dotenvParser) next() byte {
+	c := p.s[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+// skipSpace skips spaces, tabs and carriage returns, but not newlines.
+func (p *dotenvParser) skipSpace() {
+	for c := p.peek(); c == ' ' || c == '\t' || c == '\r'; c = p.peek() {
+		p.next()
+	}
+}
+
+// endLine skips the rest of the line, which may only hold a comment.
+func (p *dotenvParser) endLine() error {
+	p.skipSpace()
+	if p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.next()
+		}
+	}
+	if p.eof() {
+		return nil
+	}
+	if c := p.next(); c != '\n' {
+		return p.errorf("unexpected %q after value", c)
+	}
+	return nil
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || !first && ('0' <= c && c <= '9' || c == '.' || c == '-')
+}
+
+// validEnvName reports whether name can be used as a variable name in
+// dotenv files.
+func validEnvName(name string) bool {
+	for i := range len(name) {
+		if !isNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func (p *dotenvParser) name() (string, error) {
+	start := p.pos
+	for !p.eof() && isNameChar(p.peek(), p.pos == start) {
+		p.next()
+	}
+	if p.pos == start {
+		return "", p.errorf("invalid variable name")
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *dotenvParser) value() (string, error) {
+	switch p.peek() {
+	case '\'':
+		line := p.line
+		p.next()
+		end := strings.IndexByte(p.s[p.pos:], '\'')
+		if end < 0 {
+			p.line = line
+			return "", p.errorf("unterminated single quote")
+		}
+		value := p.s[p.pos : p.pos+end]
+		for range end + 1 {
+			p.next()
+		}
+		return value, nil
+	case '"':
+		line := p.line
+		p.next()
+		var b strings.Builder
+		for {
+			if p.eof() {
+				p.line = line
+				return "", p.errorf("unterminated double quote")
+			}
+			c := p.next()
+			switch {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && !p.eof():
+				switch e := p.next(); e {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				case '\\', '"', '$':
+					b.WriteByte(e)
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(e)
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+	}
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		if p.peek() == '#' && p.pos > start && strings.ContainsRune(" \t", rune(p.s[p.pos-1])) {
+			break
+		}
+		p.next()
+	}
+	return strings.TrimRight(p.s[start:p.pos], " \t\r"), nil
+}
+
+// parseDotenv parses the variables of a dotenv file.
+func parseDotenv(s string) ([]envVar, error) {
+	p := &dotenvParser{s: s, line: 1}
+	var vars []envVar
+	for !p.eof() {
+		p.skipSpace()
+		if c := p.peek(); c == '#' || c == '\n' || c == 0 {
+			if err := p.endLine(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if rest, ok := strings.CutPrefix(p.s[p.pos:], "export"); ok && rest != "" && (rest[0] == ' ' || rest[0] == '\t') {
+			p.pos += len("export")
+			p.skipSpace()
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.peek() != '=' {
+			return nil, p.errorf("missing = after %s", name)
+		}
+		p.next()
+		p.skipSpace()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+		vars = append(vars, envVar{name: name, value: value})
+	}
+	return vars, nil
+}
+
+// dotenvEscaper escapes values for double quotes.
+var dotenvEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// formatDotenv writes the variables as a dotenv file, with double
+// quoted values, so that parseDotenv returns them unchanged.
+func formatDotenv(vars []envVar) string {
+	var b strings.Builder
+	for _, v := range vars {
+		fmt.Fprintf(&b, "%s=\"%s\"\n", v.name, dotenvEscaper.Replace(v.value))
+	}
+	return b.String()
+}
+
+// mergeEnv returns environ, a list of NAME=value strings like
+// os.Environ returns, with the variables set. Variables that are
+// already in environ are replaced in place.
+func mergeEnv(environ []string, vars []envVar) []string {
+	env := append([]string(nil), environ...)
+	index := make(map[string]int)
+	for i, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		index[name] = i
+	}
+	for _, v := range vars {
+		kv := v.name + "=" + v.value
+		if i, ok := index[v.name]; ok {
+			env[i] = kv
+			continue
+		}
+		index[v.name] = len(env)
+		env = append(env, kv)
+	}
+	return env
+}
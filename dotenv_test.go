@@ -0,0 +1,122 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		s       string
+		want    []envVar
+		wantErr string
+	}{{
+		desc: "Empty",
+	}, {
+		desc: "Simple",
+		s:    "A=1\nB=two words\n",
+		want: []envVar{{"A", "1"}, {"B", "two words"}},
+	}, {
+		desc: "NoFinalNewline",
+		s:    "A=1",
+		want: []envVar{{"A", "1"}},
+	}, {
+		desc: "CRLF",
+		s:    "A=1\r\nB='2'\r\n",
+		want: []envVar{{"A", "1"}, {"B", "2"}},
+	}, {
+		desc: "Comments",
+		s:    "# comment\n\n  # indented\nA=1 # comment\nB=no#comment\nC='#' # comment\n",
+		want: []envVar{{"A", "1"}, {"B", "no#comment"}, {"C", "#"}},
+	}, {
+		desc: "Export",
+		s:    "export A=1\nexport\tB=2\nexported=3\n",
+		want: []envVar{{"A", "1"}, {"B", "2"}, {"exported", "3"}},
+	}, {
+		desc: "Spaces",
+		s:    "  A = 1  \n",
+		want: []envVar{{"A", "1"}},
+	}, {
+		desc: "EmptyValue",
+		s:    "A=\nB=''\nC=\"\"\n",
+		want: []envVar{{"A", ""}, {"B", ""}, {"C", ""}},
+	}, {
+		desc: "SingleQuotes",
+		s:    `A='literal $HOME \n "x"'`,
+		want: []envVar{{"A", `literal $HOME \n "x"`}},
+	}, {
+		desc: "DoubleQuotes",
+		s:    `A="tab\there\nnew \"quoted\" \\ \$HOME \x"`,
+		want: []envVar{{"A", "tab\there\nnew \"quoted\" \\ $HOME \\x"}},
+	}, {
+		desc: "Multiline",
+		s:    "A=\"line 1\nline 2\"\nB='x\ny'\nC=3\n",
+		want: []envVar{{"A", "line 1\nline 2"}, {"B", "x\ny"}, {"C", "3"}},
+	}, {
+		desc: "Equals",
+		s:    "URL=postgres://u:p@host/db?a=b\n",
+		want: []envVar{{"URL", "postgres://u:p@host/db?a=b"}},
+	}, {
+		desc:    "MissingEquals",
+		s:       "A=1\nB\n",
+		wantErr: "line 2",
+	}, {
+		desc:    "InvalidName",
+		s:       "1A=1\n",
+		wantErr: "line 1",
+	}, {
+		desc:    "UnterminatedQuote",
+		s:       "A=1\nB=\"open\nC=3\n",
+		wantErr: "line 2",
+	}, {
+		desc:    "TextAfterQuote",
+		s:       "A='x' y\n",
+		wantErr: "line 1",
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseDotenv(tc.s)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("parseDotenv(%q) returned error %v, want an error containing %q", tc.s, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDotenv(%q) failed: %s", tc.s, err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("parseDotenv(%q) = %q, want %q", tc.s, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	t.Parallel()
+
+	got := mergeEnv([]string{"A=1", "B=2", "C=x=y"}, []envVar{{"B", "3"}, {"D", "4"}, {"D", "5"}})
+	want := []string{"A=1", "B=3", "C=x=y", "D=5"}
+	if !slices.Equal(got, want) {
+		t.Errorf("mergeEnv returned %q, want %q", got, want)
+	}
+}
+
+func TestFormatDotenv(t *testing.T) {
+	t.Parallel()
+
+	vars := []envVar{{"A", ""}, {"B", "two words"}, {"C", "\"quoted\" \\ $HOME # x\n\r\t'"}}
+	s := formatDotenv(vars)
+	got, err := parseDotenv(s)
+	if err != nil {
+		t.Fatalf("parseDotenv(%q) failed: %s", s, err)
+	}
+	if !slices.Equal(got, vars) {
+		t.Errorf("parseDotenv(formatDotenv(%q)) = %q", vars, got)
+	}
+}
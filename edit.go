@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/google/subcommands"
+)
+
+type editCmd struct {
+	keys   keyFlags
+	suffix string
+	tmpDir string
+
+	// editor is the editor command, or "" for $VISUAL or $EDITOR.
+	editor string
+}
+
+func (*editCmd) Name() string     { return "edit" }
+func (*editCmd) Synopsis() string { return "edit an encrypted file" }
+func (*editCmd) Usage() string {
+	return `usage: sym edit [OPTION]... FILE
+Decrypt a file into a private temporary directory, open it in $VISUAL
+or $EDITOR, and encrypt it again when the editor exits. Example:
+  EDITOR=nano sym edit secrets.txt.enc
+
+The file is only encrypted again if its content changed, with the same
+password, keyfiles or shares, and the same stored file name. The
+temporary directory is created with mode 0700 in $XDG_RUNTIME_DIR or
+/dev/shm, which are in memory, so the plaintext is not written to disk.
+If neither can be used, like on macOS or Windows, edit refuses to run
+unless -tmpdir gives a directory, which should be on an encrypted or
+in-memory filesystem. The temporary directory is removed with
+everything the editor left in it when sym exits, even after an error,
+or when sym is terminated or hung up. Interrupts are left to the
+editor.
+
+The editor must not return before the file is closed: use, for example,
+EDITOR='code --wait'.
+
+` + passwordSourceUsage + "\n"
+}
+
+func (c *editCmd) SetFlags(fs *flag.FlagSet) {
+	c.keys.setFlags(fs, "edit")
+	fs.StringVar(&c.suffix, "suffix", ".enc", "`suffix` removed from the name of the file to edit")
+	fs.StringVar(&c.tmpDir, "tmpdir", "", "create the temporary directory in `dir`")
+}
+
+// privateDir creates a temporary directory that only the user can
+// access, in memory unless -tmpdir was given.
+func (c *editCmd) privateDir() (string, error) {
+	dirs := []string{c.tmpDir}
+	if c.tmpDir == "" {
+		dirs = memoryTempDirs()
+	}
+	err := errors.New("no temporary directory in memory was found (use -tmpdir to choose one, if the plaintext may be written there)")
+	for _, dir := range dirs {
+		var tmp string
+		// MkdirTemp creates the directory with mode 0700.
+		if tmp, err = os.MkdirTemp(dir, "sym-edit-"); err == nil {
+			return tmp, nil
+		}
+	}
+	return "", err
+}
+
+// runEditor opens fileName in the editor and waits for it to exit.
+// Interrupts from the terminal reach the editor too, and are left to
+// it. If sym is terminated or hung up, the signal is passed on to the
+// editor, and the changes are discarded.
+func (c *editCmd) runEditor(fileName string) error {
+	editor := cmp.Or(c.editor, os.Getenv("VISUAL"), os.Getenv("EDITOR"), defaultEditor)
+	cmd := editorCommand(editor, fileName)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// Without a handler, SIGHUP would kill sym before the temporary
+	// directory is removed.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("run editor: %s", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var sig os.Signal
+	for {
+		select {
+		case sig = <-sigs:
+			if err := cmd.Process.Signal(sig); err != nil {
+				cmd.Process.Kill()
+			}
+		case err := <-done:
+			switch {
+			case sig != nil:
+				return fmt.Errorf("%s, the changes were discarded", sig)
+			case err != nil:
+				return fmt.Errorf("editor failed, the changes were discarded: %s", err)
+			}
+			return nil
+		}
+	}
+}
+
+// decryptFile decrypts the file into memory, so that nothing is written
+// before the whole file has been authenticated.
+func decryptFile(ctx context.Context, fileName string, key keyFunc) (*decryptingReader, []byte, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	reader := newKeyedDecryptingReader(ctx, f, key)
+	h, err := reader.readHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.archive {
+		return nil, nil, errors.New("file is a directory archive")
+	}
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader, plaintext, nil
+}
+
+func (c *editCmd) run(ctx context.Context, args ...string) error {
+	if len(args) != 1 {
+		return usageErr("edit takes exactly one file")
+	}
+	if err := c.keys.check(); err != nil {
+		return err
+	}
+	fileName := args[0]
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	// Create the directory first, so that the password isn't asked for
+	// if there is nowhere to put the plaintext.
+	dir, err := c.privateDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	key, decrypted, err := c.keys.key(args)
+	if err != nil {
+		return err
+	}
+	reader, plaintext, err := decryptFile(ctx, fileName, key)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.keys.pwSource.reportFailure(err)
+		}
+		return fmt.Errorf("decrypt %q: %s", fileName, err)
+	}
+	decrypted()
+
+	// Keep the original name, so that the editor can tell the type of
+	// file.
+	name := reader.name
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		name = strings.TrimSuffix(filepath.Base(fileName), cmp.Or(c.suffix, ".enc"))
+	}
+	tmpName := filepath.Join(dir, name)
+	if err := os.WriteFile(tmpName, plaintext, 0600); err != nil {
+		return err
+	}
+	if err := c.runEditor(tmpName); err != nil {
+		return err
+	}
+	edited, err := os.ReadFile(tmpName)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, plaintext) {
+		fmt.Fprintln(os.Stderr, "sym: no changes")
+		return nil
+	}
+
+	fOut, err := createOutputFile(fileName, true)
+	if err != nil {
+		return err
+	}
+	defer fOut.abort()
+	if err := fOut.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+	// Interrupts while the editor ran were meant for the editor, so
+	// they don't stop the changes from being saved.
+	writer := newKeyedEncryptingWriter(context.WithoutCancel(ctx), fOut, sameKeyAs(reader.header, key))
+	writer.name = reader.name
+	if _, err := writer.Write(edited); err != nil {
+		return fmt.Errorf("encrypt %q: %s", fileName, err)
+	}
+	if err := writer.close(); err != nil {
+		return fmt.Errorf("encrypt %q: %s", fileName, err)
+	}
+	return fOut.commit()
+}
+
+func (c *editCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return exitStatus(ctx, c.run(ctx, f.Args()...))
+}
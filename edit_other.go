@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+const defaultEditor = "notepad"
+
+// memoryTempDirs returns the directories to try first for temporary
+// files holding plaintext. There are none on this platform.
+func memoryTempDirs() []string {
+	return nil
+}
+
+// editorCommand returns the command that opens fileName in editor. The
+// editor can have arguments separated by spaces.
+func editorCommand(editor, fileName string) *exec.Cmd {
+	args := strings.Fields(editor)
+	return exec.Command(args[0], append(args[1:], fileName)...)
+}
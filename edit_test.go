@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEditCmd_Run(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	oldContent := []byte("old content")
+	newContent := []byte("new content")
+	for _, tc := range []struct {
+		desc     string
+		keyfile  bool
+		password string
+		// editor is the editor command. {new} is replaced with the name
+		// of a file holding newContent.
+		editor      string
+		wantErr     bool
+		wantContent []byte
+	}{{
+		desc:        "Changed",
+		password:    "asdf",
+		editor:      "cp '{new}'",
+		wantContent: newContent,
+	}, {
+		desc:        "Unchanged",
+		password:    "asdf",
+		editor:      "true",
+		wantContent: oldContent,
+	}, {
+		desc:        "EditorFails",
+		password:    "asdf",
+		editor:      "cp '{new}' \"$1\" && false",
+		wantErr:     true,
+		wantContent: oldContent,
+	}, {
+		desc:        "WrongPassword",
+		password:    "wrong",
+		editor:      "cp '{new}'",
+		wantErr:     true,
+		wantContent: oldContent,
+	}, {
+		desc:        "Keyfile",
+		keyfile:     true,
+		password:    "asdf",
+		editor:      "cp '{new}'",
+		wantContent: newContent,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			var keyfiles stringsFlag
+			key := passwordKey("asdf")
+			if tc.keyfile {
+				keyfiles = stringsFlag{filepath.Join(dir, "key")}
+				if err := (&keygenCmd{keyfile: keyfiles[0]}).run(); err != nil {
+					t.Fatalf("keygen failed: %s", err)
+				}
+				secret, err := readKeyfiles(keyfiles)
+				if err != nil {
+					t.Fatal(err)
+				}
+				key = (&keyfileKey{password: key, secret: secret}).encryptKey
+			}
+			fileName := filepath.Join(dir, "notes.txt")
+			mustWriteFile(t, fileName, oldContent)
+			if err := (&encCmd{}).encryptFile(t.Context(), fileName, key); err != nil {
+				t.Fatalf("encryptFile failed: %s", err)
+			}
+			mustRemove(t, fileName)
+			fileName += ".enc"
+			if err := os.Chmod(fileName, 0600); err != nil {
+				t.Fatal(err)
+			}
+			newFile := filepath.Join(dir, "new")
+			mustWriteFile(t, newFile, newContent)
+			tmpDir := filepath.Join(dir, "tmp")
+			if err := os.Mkdir(tmpDir, 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			c := &editCmd{
+				keys:   keyFlags{password: tc.password, keyfiles: keyfiles},
+				tmpDir: tmpDir,
+				editor: strings.ReplaceAll(tc.editor, "{new}", newFile),
+			}
+			err := c.run(t.Context(), fileName)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("editCmd.run returned error %v, want error? %t", err, tc.wantErr)
+			}
+
+			if entries, err := os.ReadDir(tmpDir); err != nil || len(entries) > 0 {
+				t.Errorf("Temporary directory has entries %v (error %v), want none", entries, err)
+			}
+			if fi, err := os.Stat(fileName); err != nil || fi.Mode().Perm() != 0600 {
+				t.Errorf("Edited file has mode %v (error %v), want 0600", fi.Mode().Perm(), err)
+			}
+			decKey := passwordKey("asdf")
+			if tc.keyfile {
+				secret, err := readKeyfiles(keyfiles)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decKey = (&keyfileKey{password: decKey, secret: secret}).decryptKey
+			}
+			reader, got, err := decryptFile(t.Context(), fileName, decKey)
+			if err != nil {
+				t.Fatalf("Decrypting the edited file failed: %s", err)
+			}
+			if !bytes.Equal(got, tc.wantContent) {
+				t.Errorf("Edited file has contents %q, want %q", got, tc.wantContent)
+			}
+			if reader.name != "notes.txt" {
+				t.Errorf("Edited file has stored name %q, want %q", reader.name, "notes.txt")
+			}
+		})
+	}
+}
+
+func TestEditCmd_Run_Archive(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	if err := os.Mkdir(archive, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mustWriteFile(t, filepath.Join(archive, "file"), []byte("test file content"))
+	enc := &encCmd{password: "asdf", recursive: true, allowWeak: true}
+	if err := enc.run(t.Context(), archive); err != nil {
+		t.Fatalf("enc failed: %s", err)
+	}
+	c := &editCmd{keys: keyFlags{password: "asdf"}, editor: "true", tmpDir: dir}
+	if err := c.run(t.Context(), archive+".tar.enc"); err == nil {
+		t.Error("editCmd.run succeeded for a directory archive")
+	}
+}
+
+func TestEditCmd_Run_NoTmpDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "file")
+	mustWriteFile(t, fileName, []byte("test file content"))
+	if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey("asdf")); err != nil {
+		t.Fatalf("encryptFile failed: %s", err)
+	}
+	c := &editCmd{
+		keys: keyFlags{passwordIn: func(string) (string, error) {
+			t.Error("edit asked for the password without a temporary directory")
+			return "asdf", nil
+		}},
+		editor: "true",
+		tmpDir: filepath.Join(dir, "nonexistent"),
+	}
+	if err := c.run(t.Context(), fileName+".enc"); err == nil {
+		t.Error("editCmd.run succeeded without a temporary directory")
+	}
+}
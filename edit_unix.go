@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+const defaultEditor = "vi"
+
+// memoryTempDirs returns the directories to try first for temporary
+// files holding plaintext, which are usually in memory.
+func memoryTempDirs() []string {
+	var dirs []string
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	return append(dirs, "/dev/shm")
+}
+
+// editorCommand returns the command that opens fileName in editor. The
+// editor is run with the shell, so it can have arguments, like git
+// does.
+func editorCommand(editor, fileName string) *exec.Cmd {
+	return exec.Command("sh", "-c", editor+` "$@"`, editor, fileName)
+}
@@ -1,26 +1,43 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"crypto/rand"
-	"encoding/binary"
+	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/google/subcommands"
-	"roseh.moe/pkg/wordlist"
 )
 
 type encCmd struct {
 	generatePassword bool
 	password         string
 	force            bool
+	recursive        bool
+	mirror           bool
+	remove           bool
+	overwrite        bool
+	output           string
+	dir              string
+	suffix           string
+	pwSource         passwordSource
+	agent            *agentClient
+	keyfiles         stringsFlag
+	noPassword       bool
+	allowWeak        bool
+	blocklist        string
+	shares           string
+	shareDir         string
 
-	passwordIn  func() (string, error)
+	passwordIn  func(prompt string) (string, error)
 	passwordOut io.Writer
 	stdin       io.Reader
 	stdout      io.Writer
@@ -32,72 +49,222 @@ func (*encCmd) Usage() string {
 	return `usage: sym enc [OPTION]... [FILE]...
 Encrypt files, or stdin if no files are provided.
 
-One of -g or -p must be used when reading from stdin. When encrypting to
-stdout, consider redirecting the result since binary output can mess up
-your terminal. Example:
-  echo test | sym enc -p 'my super secure password' | base64
+When encrypting to stdout, consider redirecting the result since binary
+output can mess up your terminal. Example:
+  echo test | sym enc | base64
+The password is read from the terminal, so stdin can be used for data.
 
-`
+With -r, directories are encrypted into a single archive, so
+  sym enc -r photos/
+would write photos.tar.enc. Decrypting it with sym dec recreates the
+photos directory.
+
+With -mirror, enc takes a source and a destination directory, and
+encrypts each file under the source into its own file under the
+destination. File and directory names are encrypted too. Example:
+  sym enc -mirror photos/ /mnt/backup/photos/
+
+Encrypted files are written next to the input with the .enc suffix,
+unless -o or -C is used. The original file name is stored inside the
+encrypted file, so sym dec -N can restore it. -o also works when
+reading from stdin.
+
+With -rm, each file is removed once its encrypted copy has been written
+to disk and decrypted again to check that it matches. Add -overwrite to
+overwrite the file with random data before removing it. Overwriting is
+best-effort only: on copy-on-write filesystems, SSDs and filesystems
+with snapshots, the old contents can survive anyway. -rm refuses
+symlinks, since removing one would leave the file it points to.
+
+With -keyfile, the contents of one or more files are mixed into the key,
+so that decrypting needs both the password and the keyfiles. With
+-no-password, only the keyfiles are used. Use sym keygen to create a
+keyfile.
+
+Passwords chosen by hand are checked against common passwords, words,
+names, keyboard patterns and dates, and the time an attacker would need
+to guess them is estimated. enc warns about weak passwords, and refuses
+very weak ones unless -allow-weak is given. With -blocklist, or if
+$SYM_BLOCKLIST is set, passwords found in the blocklist are refused
+too. Use sym blocklist build to make a blocklist.
+
+With -shares k/n, no password is used. Instead, a random key is split
+into n shares, any k of which are needed to decrypt, and fewer reveal
+nothing. Each share is printed as words that can be written down and
+typed back, or written to a file in the directory given with -share-dir.
+Example:
+  sym enc -shares 3/5 -share-dir /mnt/usb secrets.txt
+  sym dec -share-file share-1.txt -share-file share-4.txt -share '...' secrets.txt.enc
+
+` + passwordSourceUsage + "\n"
 }
 
 func (c *encCmd) SetFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&c.generatePassword, "g", false, "generate a secure password automatically (password will be printed to stderr)")
 	fs.StringVar(&c.password, "p", "", "use the specified password; if not provided, enc will prompt for a password")
 	fs.BoolVar(&c.force, "f", false, "overwrite output files even if they already exist")
+	fs.BoolVar(&c.recursive, "r", false, "encrypt directories recursively into a single archive")
+	fs.BoolVar(&c.mirror, "mirror", false, "encrypt the tree under the first directory into the second, one file at a time")
+	fs.BoolVar(&c.remove, "rm", false, "remove the original files after encrypting them")
+	fs.BoolVar(&c.remove, "in-place", false, "same as -rm")
+	fs.BoolVar(&c.overwrite, "overwrite", false, "with -rm, overwrite the original files before removing them")
+	fs.StringVar(&c.output, "o", "", "write the output to `file` (only with a single input)")
+	fs.StringVar(&c.dir, "C", "", "write the output files into `dir`")
+	fs.StringVar(&c.suffix, "suffix", ".enc", "`suffix` added to the names of the output files")
+	c.pwSource.setFlags(fs)
+	fs.Var(&c.keyfiles, "keyfile", "mix the contents of `file` into the key (may be repeated)")
+	fs.BoolVar(&c.noPassword, "no-password", false, "with -keyfile, use only the keyfiles and no password")
+	fs.BoolVar(&c.allowWeak, "allow-weak", false, "use the password even if it is very weak")
+	fs.StringVar(&c.blocklist, "blocklist", os.Getenv(blocklistEnv), "refuse passwords found in the blocklist `file`")
+	fs.StringVar(&c.shares, "shares", "", "split a random key into `k/n` shares instead of using a password")
+	fs.StringVar(&c.shareDir, "share-dir", "", "with -shares, write the shares to files in `dir`")
 }
 
-func (c *encCmd) encrypt(w io.Writer, r io.Reader, password string) error {
-	writer := newEncryptingWriter(w, password)
+func (c *encCmd) encrypt(ctx context.Context, w io.Writer, r io.Reader, key keyFunc) error {
+	writer := newKeyedEncryptingWriter(ctx, w, key)
 	if _, err := io.Copy(writer, r); err != nil {
 		return err
 	}
 	return writer.close()
 }
 
-func (c *encCmd) encryptFile(fileName string, password string) (err error) {
+// outputName returns the name of the file that fileName is encrypted to.
+func (c *encCmd) outputName(fileName string) string {
+	if c.output != "" {
+		return c.output
+	}
+	name := fileName + cmp.Or(c.suffix, ".enc")
+	if c.dir != "" {
+		return filepath.Join(c.dir, filepath.Base(name))
+	}
+	return name
+}
+
+func (c *encCmd) encryptFile(ctx context.Context, fileName string, key keyFunc) (err error) {
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if !c.recursive {
+			return fmt.Errorf("%q is a directory (use -r to encrypt directories)", fileName)
+		}
+		return c.encryptDir(ctx, fileName, key)
+	}
+	if c.remove {
+		if err := checkRemovable(fileName); err != nil {
+			return err
+		}
+	}
 	f, err := os.Open(fileName)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	fileOpts := os.O_CREATE | os.O_WRONLY
-	if c.force {
-		fileOpts |= os.O_TRUNC
-	} else {
-		fileOpts |= os.O_EXCL
+	if err := checkNotInput(f, c.outputName(fileName)); err != nil {
+		return err
 	}
-	fOut, err := os.OpenFile(fileName+".enc", fileOpts, 0644)
+	fOut, err := createOutputFile(c.outputName(fileName), c.force)
 	if err != nil {
-		if errors.Is(err, os.ErrExist) {
-			return fmt.Errorf("output file %q exists (use -f to overwrite)", fileName+".enc")
-		}
 		return err
 	}
-	defer func() {
-		fOut.Close()
+	defer fOut.abort()
+	// Remember the key, so that the output can be verified without
+	// hashing the password again.
+	var fileKey []byte
+	writer := newKeyedEncryptingWriter(ctx, fOut, func(h *header) ([]byte, error) {
+		k, err := key(h)
+		fileKey = k
+		return k, err
+	})
+	writer.name = filepath.Base(fileName)
+	h := sha256.New()
+	if _, err := io.Copy(writer, io.TeeReader(f, h)); err != nil {
+		return fmt.Errorf("encrypt %q: %s", fileName, err)
+	}
+	if err := writer.close(); err != nil {
+		return fmt.Errorf("encrypt %q: %s", fileName, err)
+	}
+	if err := fOut.commit(); err != nil {
+		return err
+	}
+	if !c.remove {
+		return nil
+	}
+	if err := verifyFile(ctx, fOut.name, fileKey, h.Sum(nil)); err != nil {
+		return fmt.Errorf("verify %q: %s", fOut.name, err)
+	}
+	return removeFile(fileName, c.overwrite)
+}
+
+// verifyFile decrypts the file with the given key and checks that the
+// SHA-256 hash of the plaintext is sum.
+func verifyFile(ctx context.Context, fileName string, key []byte, sum []byte) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	r := newKeyedDecryptingReader(ctx, f, func(*header) ([]byte, error) { return key, nil })
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return errors.New("decrypted content does not match the original")
+	}
+	return nil
+}
+
+func (c *encCmd) encryptDir(ctx context.Context, dir string, key keyFunc) (err error) {
+	dir = filepath.Clean(dir)
+	archiveName := dir + ".tar"
+	if base := filepath.Base(dir); base == "." || base == ".." {
+		// Make sure the output file ends up outside the directory.
+		abs, err := filepath.Abs(dir)
 		if err != nil {
-			os.Remove(fOut.Name())
+			return err
 		}
-	}()
-	if err = c.encrypt(fOut, f, password); err != nil {
-		return fmt.Errorf("encrypt %q: %s", fileName, err)
+		archiveName = abs + ".tar"
+	}
+	fOut, err := createOutputFile(c.outputName(archiveName), c.force)
+	if err != nil {
+		return err
+	}
+	defer fOut.abort()
+	writer := newKeyedEncryptingWriter(ctx, fOut, key)
+	writer.header.archive = true
+	if err := writeArchive(writer, dir); err != nil {
+		return fmt.Errorf("encrypt %q: %s", dir, err)
 	}
-	return fOut.Close()
+	if err := writer.close(); err != nil {
+		return fmt.Errorf("encrypt %q: %s", dir, err)
+	}
+	return fOut.commit()
+}
+
+// encryptStdin encrypts stdin to the file given with -o.
+func (c *encCmd) encryptStdin(ctx context.Context, key keyFunc) error {
+	fOut, err := createOutputFile(c.output, c.force)
+	if err != nil {
+		return err
+	}
+	defer fOut.abort()
+	if err := c.encrypt(ctx, fOut, c.stdin, key); err != nil {
+		return err
+	}
+	return fOut.commit()
 }
 
 func (c *encCmd) readPassword() (string, error) {
-	fmt.Fprint(os.Stderr, "Enter password: ")
-	password, err := c.passwordIn()
-	fmt.Fprintln(os.Stderr)
+	password, err := c.passwordIn("Enter password: ")
 	if err != nil {
 		return "", err
 	}
 	if password == "" {
 		return "", usageErr("password cannot be empty")
 	}
-	fmt.Fprint(os.Stderr, "Repeat password: ")
-	pwConfirm, err := c.passwordIn()
-	fmt.Fprintln(os.Stderr)
+	pwConfirm, err := c.passwordIn("Repeat password: ")
 	if err != nil {
 		return "", err
 	}
@@ -107,39 +274,207 @@ func (c *encCmd) readPassword() (string, error) {
 	return password, nil
 }
 
-func (c *encCmd) run(args ...string) error {
-	if c.generatePassword && c.password != "" {
-		return usageErr("-g and -p cannot be used together")
+// checkStrength warns if the password is weak, and refuses it if it is
+// very weak and -allow-weak was not given.
+func (c *encCmd) checkStrength(password string, args []string) error {
+	var inputs []string
+	for _, arg := range args {
+		name := filepath.Base(arg)
+		inputs = append(inputs, name, strings.TrimSuffix(name, filepath.Ext(name)))
 	}
-	if len(args) == 0 && !c.generatePassword && c.password == "" {
-		return usageErr("must use -g or -p when reading from stdin")
+	seconds := crackTime(estimateGuesses(password, inputs))
+	switch {
+	case seconds < minCrackTime && !c.allowWeak:
+		return usageErr("password is too weak, it could be cracked in %s (use -allow-weak to use it anyway)", formatCrackTime(seconds))
+	case seconds < warnCrackTime:
+		fmt.Fprintf(os.Stderr, "sym: warning: weak password, it could be cracked in %s\n", formatCrackTime(seconds))
 	}
-	var password string
-	if c.password != "" {
+	return nil
+}
+
+// checkBlocklist refuses the password if it is in the blocklist.
+func (c *encCmd) checkBlocklist(password string) error {
+	if c.blocklist == "" {
+		return nil
+	}
+	found, err := blocklisted(c.blocklist, password)
+	if err != nil {
+		return err
+	}
+	if found {
+		return usageErr("password is in the blocklist %q, choose another one", c.blocklist)
+	}
+	return nil
+}
+
+// passwordKey returns the key function for the password, which is
+// taken from the flags or the agent, or read from the terminal.
+func (c *encCmd) passwordKey(args []string) (keyFunc, error) {
+	prompted := false
+	password, ok, err := c.pwSource.read("encrypt", args)
+	switch {
+	case err != nil:
+		return nil, err
+	case ok:
+		// The password was read from one of the -password-* flags.
+	case c.password != "":
+		warnPasswordFlag()
 		password = c.password
-	} else if c.generatePassword {
-		const nWords = 10
-		buf := make([]byte, 2*nWords)
-		rand.Read(buf)
-		words := make([]string, nWords)
-		for i := range words {
-			words[i] = wordlist.Words[binary.NativeEndian.Uint16(buf[2*i:])&0x1fff]
-		}
-		password = strings.Join(words, " ")
+	case c.generatePassword:
+		password = (&passphraseGenerator{nWords: defaultWords, separator: " ", checksum: true}).generate()
 		fmt.Fprint(os.Stderr, "Your password: ")
 		fmt.Fprint(c.passwordOut, password)
 		fmt.Fprintln(os.Stderr)
-	} else {
-		var err error
+		return passwordKey(password), nil
+	case c.agent.unlocked():
+		fmt.Fprintln(os.Stderr, "sym: encrypting with the password held by the agent (use sym agent -lock to use another)")
+		return c.agent.key, nil
+	default:
 		if password, err = c.readPassword(); err != nil {
+			return nil, err
+		}
+		prompted = true
+	}
+	if err := c.checkBlocklist(password); err != nil {
+		return nil, err
+	}
+	if err := c.checkStrength(password, args); err != nil {
+		return nil, err
+	}
+	if prompted {
+		c.agent.remember(password)
+	}
+	return passwordKey(password), nil
+}
+
+// sharesKey generates a random key, splits it into shares as given by
+// -shares, and prints or writes out the shares.
+func (c *encCmd) sharesKey() (keyFunc, error) {
+	k, n, err := parseShares(c.shares)
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, shareSecretSize)
+	rand.Read(secret)
+	shares := splitSecret(secret, k, n)
+	for i, sh := range shares {
+		if c.shareDir == "" {
+			fmt.Fprintf(os.Stderr, "Share %d of %d: ", i+1, n)
+			fmt.Fprint(c.passwordOut, sh.encode())
+			fmt.Fprintln(os.Stderr)
+			continue
+		}
+		fOut, err := createOutputFile(filepath.Join(c.shareDir, fmt.Sprintf("share-%d.txt", i+1)), c.force)
+		if err != nil {
+			return nil, err
+		}
+		defer fOut.abort()
+		// Shares are secrets, only their owner should read them.
+		if err := fOut.Chmod(0600); err != nil {
+			return nil, err
+		}
+		if _, err := fmt.Fprintln(fOut, sh.encode()); err != nil {
+			return nil, err
+		}
+		if err := fOut.commit(); err != nil {
+			return nil, err
+		}
+	}
+	if c.shareDir != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d shares to %q, %d are needed to decrypt\n", n, c.shareDir, k)
+	}
+	return (&sharesKey{secret: secret}).encryptKey, nil
+}
+
+func (c *encCmd) run(ctx context.Context, args ...string) error {
+	nPasswords := c.pwSource.count()
+	if c.password != "" {
+		nPasswords++
+	}
+	if c.generatePassword {
+		nPasswords++
+	}
+	if nPasswords > 1 {
+		return usageErr("only one of -g, -p and the -password-* flags can be used")
+	}
+	if c.noPassword && len(c.keyfiles) == 0 {
+		return usageErr("-no-password requires -keyfile")
+	}
+	if c.noPassword && nPasswords > 0 {
+		return usageErr("-no-password cannot be used with a password")
+	}
+	if c.mirror && len(args) != 2 {
+		return usageErr("-mirror requires a source and a destination directory")
+	}
+	if c.mirror && c.recursive {
+		return usageErr("-r and -mirror cannot be used together")
+	}
+	if len(args) == 0 && c.recursive {
+		return usageErr("-r cannot be used when reading from stdin")
+	}
+	if c.remove && (c.recursive || c.mirror) {
+		return usageErr("-rm cannot be used with -r or -mirror")
+	}
+	if c.remove && len(args) == 0 {
+		return usageErr("-rm cannot be used when reading from stdin")
+	}
+	if c.overwrite && !c.remove {
+		return usageErr("-overwrite requires -rm")
+	}
+	if c.output != "" && len(args) > 1 {
+		return usageErr("-o cannot be used with more than one input")
+	}
+	if c.output != "" && c.dir != "" {
+		return usageErr("-o and -C cannot be used together")
+	}
+	if c.mirror && (c.output != "" || c.dir != "") {
+		return usageErr("-o and -C cannot be used with -mirror")
+	}
+	if len(args) == 0 && c.pwSource.hasFD && c.pwSource.fd == 0 {
+		return usageErr("-password-fd 0 cannot be used when reading from stdin")
+	}
+	if c.shares != "" && (nPasswords > 0 || c.noPassword || len(c.keyfiles) > 0) {
+		return usageErr("-shares cannot be used with a password or -keyfile")
+	}
+	if c.shares != "" && c.mirror {
+		return usageErr("-shares cannot be used with -mirror")
+	}
+	if c.shareDir != "" && c.shares == "" {
+		return usageErr("-share-dir requires -shares")
+	}
+	var key keyFunc
+	if c.shares != "" {
+		var err error
+		if key, err = c.sharesKey(); err != nil {
+			return err
+		}
+	} else if !c.noPassword {
+		var err error
+		if key, err = c.passwordKey(args); err != nil {
 			return err
 		}
 	}
+	if len(c.keyfiles) > 0 {
+		secret, err := readKeyfiles(c.keyfiles)
+		if err != nil {
+			return err
+		}
+		key = (&keyfileKey{password: key, secret: secret}).encryptKey
+	}
+	if len(args) == 0 && c.output != "" {
+		return c.encryptStdin(ctx, key)
+	}
 	if len(args) == 0 {
-		return c.encrypt(c.stdout, c.stdin, password)
+		return c.encrypt(ctx, c.stdout, c.stdin, key)
+	}
+	if c.overwrite {
+		fmt.Fprintln(os.Stderr, "sym: warning: overwriting cannot reliably erase data on copy-on-write filesystems or SSDs")
+	}
+	if c.mirror {
+		return encryptTree(ctx, args[0], args[1], key, c.force)
 	}
 	for _, fileName := range args {
-		if err := c.encryptFile(fileName, password); err != nil {
+		if err := c.encryptFile(ctx, fileName, key); err != nil {
 			return err
 		}
 	}
@@ -147,12 +482,5 @@ func (c *encCmd) run(args ...string) error {
 }
 
 func (c *encCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
-	if err := c.run(f.Args()...); err != nil {
-		fmt.Fprintf(os.Stderr, "sym: %s\n", err)
-		if errors.Is(err, errUsage) {
-			return subcommands.ExitUsageError
-		}
-		return subcommands.ExitFailure
-	}
-	return subcommands.ExitSuccess
+	return exitStatus(ctx, c.run(ctx, f.Args()...))
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -30,7 +32,7 @@ func TestEncryptFile_Force(t *testing.T) {
 			fileName := filepath.Join(t.TempDir(), "file")
 			mustWriteFile(t, fileName, []byte("test file content"))
 			mustWriteFile(t, fileName+".enc", []byte("file already exists"))
-			err := (&encCmd{force: tc.force}).encryptFile(fileName, "asdf")
+			err := (&encCmd{force: tc.force}).encryptFile(t.Context(), fileName, passwordKey("asdf"))
 			if gotErr := err != nil; gotErr != tc.wantErr {
 				t.Errorf("EncryptFile(force=%t) returned returned error %v when output file exists, want error? %t", tc.force, err, tc.wantErr)
 			}
@@ -38,10 +40,60 @@ func TestEncryptFile_Force(t *testing.T) {
 	}
 }
 
+func TestEncryptFile_OutputName(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc   string
+		output string
+		dir    string
+		suffix string
+		want   string
+	}{{
+		desc: "Default",
+		want: "in/file.enc",
+	}, {
+		desc:   "Suffix",
+		suffix: ".sym",
+		want:   "in/file.sym",
+	}, {
+		desc: "Dir",
+		dir:  "out",
+		want: "out/file.enc",
+	}, {
+		desc:   "Output",
+		output: "out/encrypted",
+		want:   "out/encrypted",
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			mustMkdir(t, filepath.Join(dir, "in"))
+			mustMkdir(t, filepath.Join(dir, "out"))
+			fileName := filepath.Join(dir, "in", "file")
+			mustWriteFile(t, fileName, []byte("test file content"))
+			c := &encCmd{suffix: tc.suffix}
+			if tc.output != "" {
+				c.output = filepath.Join(dir, tc.output)
+			}
+			if tc.dir != "" {
+				c.dir = filepath.Join(dir, tc.dir)
+			}
+			if err := c.encryptFile(t.Context(), fileName, passwordKey("asdf")); err != nil {
+				t.Fatalf("encryptFile failed: %s", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, tc.want)); err != nil {
+				t.Errorf("Output file was not created: %s", err)
+			}
+		})
+	}
+}
+
 func TestEncryptFile_NotFound(t *testing.T) {
 	t.Parallel()
 
-	err := (&encCmd{}).encryptFile("my-nonexistent-file.txt", "asdf")
+	err := (&encCmd{}).encryptFile(t.Context(), "my-nonexistent-file.txt", passwordKey("asdf"))
 	if err == nil {
 		t.Fatal("encryptFile succeeded for nonexistent file, want error")
 	}
@@ -50,11 +102,14 @@ func TestEncryptFile_NotFound(t *testing.T) {
 func TestEncryptFile_NoPermission(t *testing.T) {
 	t.Parallel()
 
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to any file")
+	}
 	fileName := filepath.Join(t.TempDir(), "file")
 	mustWriteFile(t, fileName, []byte("test file content"))
 	mustWriteFile(t, fileName+".enc", nil)
 	mustChmod(t, fileName+".enc", 0400)
-	err := (&encCmd{force: true}).encryptFile(fileName, "asdf")
+	err := (&encCmd{force: true}).encryptFile(t.Context(), fileName, passwordKey("asdf"))
 	if err == nil {
 		t.Fatal("encryptFile succeeded for unwritable file, want error")
 	}
@@ -67,11 +122,11 @@ func TestEncCmd_Run(t *testing.T) {
 	fileContent := []byte("test file content")
 	fileName := filepath.Join(t.TempDir(), "file")
 	mustWriteFile(t, fileName, fileContent)
-	if err := (&encCmd{password: password}).run(fileName); err != nil {
+	if err := (&encCmd{password: password, allowWeak: true}).run(t.Context(), fileName); err != nil {
 		t.Fatalf("enc failed: %s", err)
 	}
 	mustRemove(t, fileName)
-	if err := (&decCmd{}).decryptFile(fileName+".enc", password); err != nil {
+	if err := (&decCmd{}).decryptFile(t.Context(), fileName+".enc", passwordKey(password)); err != nil {
 		t.Fatalf("Failed to decrypt encrypted file: %s", err)
 	}
 	gotFileContents := mustReadFile(t, fileName)
@@ -80,6 +135,78 @@ func TestEncCmd_Run(t *testing.T) {
 	}
 }
 
+func TestEncCmd_Run_Remove(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc      string
+		overwrite bool
+	}{{
+		desc: "Remove",
+	}, {
+		desc:      "Overwrite",
+		overwrite: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			const password = "asdf"
+			fileContent := []byte("test file content")
+			fileName := filepath.Join(t.TempDir(), "file")
+			mustWriteFile(t, fileName, fileContent)
+			if err := (&encCmd{password: password, allowWeak: true, remove: true, overwrite: tc.overwrite}).run(t.Context(), fileName); err != nil {
+				t.Fatalf("enc -rm failed: %s", err)
+			}
+			if _, err := os.Stat(fileName); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("enc -rm left the original file behind: %v", err)
+			}
+			if err := (&decCmd{}).decryptFile(t.Context(), fileName+".enc", passwordKey(password)); err != nil {
+				t.Fatalf("Failed to decrypt encrypted file: %s", err)
+			}
+			if got := mustReadFile(t, fileName); !bytes.Equal(got, fileContent) {
+				t.Errorf("Decrypted file has contents %q, want %q", got, fileContent)
+			}
+		})
+	}
+}
+
+func TestEncCmd_Run_RemoveRefused(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		symlink bool
+	}{{
+		desc: "OutputIsInput",
+	}, {
+		desc:    "Symlink",
+		symlink: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			fileContent := []byte("test file content")
+			fileName := filepath.Join(t.TempDir(), "file")
+			mustWriteFile(t, fileName, fileContent)
+			c := &encCmd{password: "asdf", allowWeak: true, remove: true, force: true, output: fileName}
+			input := fileName
+			if tc.symlink {
+				input = fileName + ".link"
+				if err := os.Symlink(fileName, input); err != nil {
+					t.Skipf("Failed to create symlink: %s", err)
+				}
+				c.output = fileName + ".enc"
+			}
+			if err := c.run(t.Context(), input); err == nil {
+				t.Errorf("encCmd.run(%+v) succeeded, want error", c)
+			}
+			if got := mustReadFile(t, fileName); !bytes.Equal(got, fileContent) {
+				t.Errorf("Input file has contents %q, want %q", got, fileContent)
+			}
+		})
+	}
+}
+
 func TestEncCmd_Run_UsageError(t *testing.T) {
 	t.Parallel()
 
@@ -87,19 +214,26 @@ func TestEncCmd_Run_UsageError(t *testing.T) {
 		desc             string
 		generatePassword bool
 		password         string
+		recursive        bool
+		overwrite        bool
 		files            []string
 	}{{
 		desc:             "GeneratePasswordAndPassword",
 		generatePassword: true,
 		password:         "asdf",
 	}, {
-		desc:             "MissingPasswordStdin",
-		generatePassword: false,
-		password:         "",
+		desc:      "RecursiveStdin",
+		password:  "asdf",
+		recursive: true,
 	}, {
 		desc:     "NonexistentFile",
 		password: "asdf",
 		files:    []string{"my-nonexistent-file.txt"},
+	}, {
+		desc:      "OverwriteWithoutRemove",
+		password:  "asdf",
+		overwrite: true,
+		files:     []string{"my-nonexistent-file.txt"},
 	}} {
 		t.Run(tc.desc, func(t *testing.T) {
 			t.Parallel()
@@ -107,8 +241,11 @@ func TestEncCmd_Run_UsageError(t *testing.T) {
 			opts := &encCmd{
 				generatePassword: tc.generatePassword,
 				password:         tc.password,
+				allowWeak:        true,
+				recursive:        tc.recursive,
+				overwrite:        tc.overwrite,
 			}
-			if err := opts.run(tc.files...); err == nil {
+			if err := opts.run(t.Context(), tc.files...); err == nil {
 				t.Errorf("encCmd.run(%+v) succeeded, want error", opts)
 			}
 		})
@@ -127,12 +264,12 @@ func TestEncCmd_Run_GeneratePassword(t *testing.T) {
 		generatePassword: true,
 		passwordOut:      password,
 	}
-	if err := opts.run(fileName); err != nil {
+	if err := opts.run(t.Context(), fileName); err != nil {
 		t.Fatalf("encCmd.run(%+v) failed: %s", opts, err)
 	}
 	pw := password.String()
 	mustRemove(t, fileName)
-	if err := (&decCmd{}).decryptFile(fileName+".enc", pw); err != nil {
+	if err := (&decCmd{}).decryptFile(t.Context(), fileName+".enc", passwordKey(pw)); err != nil {
 		t.Fatalf("Failed to decrypt encrypted file with generated password %q: %s", pw, err)
 	}
 	gotFileContents := mustReadFile(t, fileName)
@@ -150,14 +287,15 @@ func TestEncCmd_Run_Stdin(t *testing.T) {
 	)
 	stdout := new(strings.Builder)
 	if err := (&encCmd{
-		password: password,
-		stdin:    strings.NewReader(input),
-		stdout:   stdout,
-	}).run(); err != nil {
+		password:  password,
+		allowWeak: true,
+		stdin:     strings.NewReader(input),
+		stdout:    stdout,
+	}).run(t.Context()); err != nil {
 		t.Errorf("encCmd.run failed: %s", err)
 	}
 	got := new(strings.Builder)
-	if err := (&decCmd{}).decrypt(got, strings.NewReader(stdout.String()), password); err != nil {
+	if err := (&decCmd{}).decrypt(t.Context(), got, strings.NewReader(stdout.String()), passwordKey(password)); err != nil {
 		t.Errorf("Failed to decrypt stdout content %q: %s", stdout, err)
 	}
 	if got, want := got.String(), input; got != want {
@@ -165,17 +303,55 @@ func TestEncCmd_Run_Stdin(t *testing.T) {
 	}
 }
 
+func TestEncCmd_Run_StdinReadPassword(t *testing.T) {
+	t.Parallel()
+
+	const (
+		input    = "test input"
+		password = "asdf"
+	)
+	stdout := new(strings.Builder)
+	if err := (&encCmd{
+		passwordIn: func(string) (string, error) { return password, nil },
+		allowWeak:  true,
+		stdin:      strings.NewReader(input),
+		stdout:     stdout,
+	}).run(t.Context()); err != nil {
+		t.Fatalf("encCmd.run failed: %s", err)
+	}
+	got := new(strings.Builder)
+	if err := (&decCmd{
+		keys:   keyFlags{passwordIn: func(string) (string, error) { return password, nil }},
+		stdin:  strings.NewReader(stdout.String()),
+		stdout: got,
+	}).run(t.Context()); err != nil {
+		t.Fatalf("decCmd.run failed: %s", err)
+	}
+	if got, want := got.String(), input; got != want {
+		t.Errorf("Encrypt round-trip through stdin returned incorrect contents: %q, want %q", got, want)
+	}
+}
+
 func TestEncCmd_Run_ReadPassword(t *testing.T) {
 	t.Parallel()
 
 	for _, tc := range []struct {
 		desc      string
 		passwords []string
+		allowWeak bool
 		err       error
 		wantErr   bool
 	}{{
 		desc:      "Ok",
+		passwords: []string{"kq7#Zv9!mW2@xR5$pL8&"},
+	}, {
+		desc:      "WeakPassword",
 		passwords: []string{"asdf"},
+		wantErr:   true,
+	}, {
+		desc:      "AllowWeak",
+		passwords: []string{"asdf"},
+		allowWeak: true,
 	}, {
 		desc:      "EmptyPassword",
 		passwords: []string{""},
@@ -202,7 +378,7 @@ func TestEncCmd_Run_ReadPassword(t *testing.T) {
 
 			passwordI := 0
 			err := (&encCmd{
-				passwordIn: func() (string, error) {
+				passwordIn: func(string) (string, error) {
 					if passwordI == len(tc.passwords) && tc.err != nil {
 						return "", tc.err
 					}
@@ -210,10 +386,31 @@ func TestEncCmd_Run_ReadPassword(t *testing.T) {
 					passwordI++
 					return pw, nil
 				},
-			}).run(fileName)
+				allowWeak: tc.allowWeak,
+			}).run(t.Context(), fileName)
 			if gotErr := err != nil; gotErr != tc.wantErr {
 				t.Errorf("encCmd.run returned error %v, want error? %t", err, tc.wantErr)
 			}
 		})
 	}
 }
+
+func TestEncryptFile_Canceled(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "file")
+	mustWriteFile(t, fileName, []byte("test file content"))
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := (&encCmd{}).encryptFile(ctx, fileName, passwordKey("asdf")); err == nil {
+		t.Fatal("encryptFile succeeded with canceled context, want error")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Canceled encryption left %d files behind, want only the input", len(entries)-1)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+)
+
+type execCmd struct {
+	keys    keyFlags
+	envFile stringsFlag
+
+	// exec runs the command with the environment, like execCommand.
+	exec func(args, env []string) error
+}
+
+func (*execCmd) Name() string { return "exec" }
+func (*execCmd) Synopsis() string {
+	return "run a command with variables from an encrypted dotenv file"
+}
+func (*execCmd) Usage() string {
+	return `usage: sym exec -env FILE [OPTION]... [--] COMMAND [ARG]...
+Decrypt dotenv files in memory, add their variables to the environment
+and run the command. The plaintext is never written to disk. Example:
+  sym exec -env prod.env.enc -- ./server -port 8080
+
+The files hold NAME=value lines, optionally starting with export.
+Values can be single quoted, which are taken literally, or double
+quoted, with the escapes \n, \r, \t, \\, \" and \$. Unquoted values end
+at a # preceded by a space, which starts a comment. Variables are not
+expanded. Variables from the files replace variables of the same name
+in the environment, and later files replace earlier ones.
+
+The command replaces sym, so it gets the signals sent to sym, and its
+exit status is the exit status of sym.
+
+` + passwordSourceUsage + "\n"
+}
+
+func (c *execCmd) SetFlags(fs *flag.FlagSet) {
+	fs.Var(&c.envFile, "env", "add the variables of the encrypted dotenv `file` (may be repeated)")
+	c.keys.setFlags(fs, "exec")
+}
+
+func (c *execCmd) run(ctx context.Context, args ...string) error {
+	if len(c.envFile) == 0 || len(args) == 0 {
+		return usageErr("exec requires -env and a command")
+	}
+	if err := c.keys.check(); err != nil {
+		return err
+	}
+	key, decrypted, err := c.keys.key(c.envFile)
+	if err != nil {
+		return err
+	}
+	var vars []envVar
+	for _, fileName := range c.envFile {
+		_, plaintext, err := decryptFile(ctx, fileName, key)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.keys.pwSource.reportFailure(err)
+			}
+			return fmt.Errorf("decrypt %q: %s", fileName, err)
+		}
+		decrypted()
+		fileVars, err := parseDotenv(string(plaintext))
+		if err != nil {
+			return fmt.Errorf("%q: %s", fileName, err)
+		}
+		vars = append(vars, fileVars...)
+	}
+	return c.exec(args, mergeEnv(os.Environ(), vars))
+}
+
+func (c *execCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return exitStatus(ctx, c.run(ctx, f.Args()...))
+}
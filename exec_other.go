@@ -0,0 +1,31 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+)
+
+// execCommand runs the command and exits with its exit status, since
+// processes can't be replaced on this platform. Interrupts reach the
+// command from the console, so sym ignores them while it runs. It only
+// returns if the command can't be started.
+func execCommand(args, env []string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	signal.Ignore(os.Interrupt)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		os.Exit(exitErr.ExitCode())
+	case err != nil:
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExecCmd_Run(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// encryptEnv writes an encrypted dotenv file and returns its name.
+	encryptEnv := func(name, content string) string {
+		fileName := filepath.Join(dir, name)
+		mustWriteFile(t, fileName, []byte(content))
+		if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey("asdf")); err != nil {
+			t.Fatalf("encryptFile failed: %s", err)
+		}
+		mustRemove(t, fileName)
+		return fileName + ".enc"
+	}
+	prod := encryptEnv("prod.env", "export DB_USER=admin\nDB_PASSWORD='s3cret'\n")
+	local := encryptEnv("local.env", "DB_USER=dev\n")
+	invalid := encryptEnv("invalid.env", "not a variable\n")
+
+	for _, tc := range []struct {
+		desc     string
+		password string
+		envFiles stringsFlag
+		args     []string
+		wantEnv  []string
+		wantErr  bool
+	}{{
+		desc:     "Env",
+		password: "asdf",
+		envFiles: stringsFlag{prod},
+		args:     []string{"./server", "-port", "8080"},
+		wantEnv:  []string{"DB_USER=admin", "DB_PASSWORD=s3cret"},
+	}, {
+		desc:     "LaterFileWins",
+		password: "asdf",
+		envFiles: stringsFlag{prod, local},
+		args:     []string{"./server"},
+		wantEnv:  []string{"DB_USER=dev", "DB_PASSWORD=s3cret"},
+	}, {
+		desc:     "WrongPassword",
+		password: "wrong",
+		envFiles: stringsFlag{prod},
+		args:     []string{"./server"},
+		wantErr:  true,
+	}, {
+		desc:     "InvalidFile",
+		password: "asdf",
+		envFiles: stringsFlag{invalid},
+		args:     []string{"./server"},
+		wantErr:  true,
+	}, {
+		desc:     "NoCommand",
+		password: "asdf",
+		envFiles: stringsFlag{prod},
+		wantErr:  true,
+	}, {
+		desc:     "NoEnv",
+		password: "asdf",
+		args:     []string{"./server"},
+		wantErr:  true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var gotArgs, gotEnv []string
+			c := &execCmd{
+				keys:    keyFlags{password: tc.password},
+				envFile: tc.envFiles,
+				exec: func(args, env []string) error {
+					gotArgs, gotEnv = args, env
+					return nil
+				},
+			}
+			err := c.run(t.Context(), tc.args...)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("execCmd.run returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if err != nil {
+				if gotArgs != nil {
+					t.Errorf("execCmd.run ran %q after an error", gotArgs)
+				}
+				return
+			}
+			if !slices.Equal(gotArgs, tc.args) {
+				t.Errorf("execCmd.run ran %q, want %q", gotArgs, tc.args)
+			}
+			for _, want := range tc.wantEnv {
+				if !slices.Contains(gotEnv, want) {
+					t.Errorf("Environment %q does not contain %q", gotEnv, want)
+				}
+			}
+		})
+	}
+}
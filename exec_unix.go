@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// execCommand replaces sym with the command, so that the command gets
+// sym's signals and its exit status is sym's. It only returns on error.
+func execCommand(args, env []string) error {
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("exec", syscall.Exec(path, args, env))
+}
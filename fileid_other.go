@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+type fileID struct{}
+
+// hardLinkID returns an identifier for a file with more than one link.
+// Hardlinks are not detected on this platform.
+func hardLinkID(fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/subcommands"
+	"roseh.moe/pkg/wordlist"
+)
+
+const (
+	// defaultWords is the number of words in generated passphrases.
+	defaultWords = 10
+
+	// maxWords is the number of words in the longest passphrase
+	// genpass generates, far more than any password needs.
+	maxWords = 1000
+)
+
+// passphraseGenerator generates passphrases of random words.
+type passphraseGenerator struct {
+	// words is the word list, or nil for wordlist.Words.
+	words      []string
+	nWords     int
+	separator  string
+	capitalize bool // capitalize each word at random
+	digits     bool // append a random digit to each word
+	checksum   bool // append a checksum word
+}
+
+func (g *passphraseGenerator) wordList() []string {
+	if g.words != nil {
+		return g.words
+	}
+	words := make([]string, len(wordlist.Words))
+	for i := range words {
+		words[i] = wordlist.Words[i]
+	}
+	return words
+}
+
+// capitalizable reports whether capitalizing word changes it.
+func capitalizable(word string) bool {
+	r, _ := utf8.DecodeRuneInString(word)
+	return unicode.ToUpper(r) != r
+}
+
+// wordEntropy returns the entropy of each word in bits.
+func (g *passphraseGenerator) wordEntropy() float64 {
+	words := g.wordList()
+	bits := math.Log2(float64(len(words)))
+	if g.capitalize {
+		// Only words that change when capitalized add a bit.
+		n := 0
+		for _, word := range words {
+			if capitalizable(word) {
+				n++
+			}
+		}
+		bits += float64(n) / float64(len(words))
+	}
+	if g.digits {
+		bits += math.Log2(10)
+	}
+	return bits
+}
+
+// entropy returns the entropy of the passphrases in bits.
+func (g *passphraseGenerator) entropy() float64 {
+	return float64(g.nWords) * g.wordEntropy()
+}
+
+// randInt returns a uniform random number in [0, n).
+func randInt(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err) // impossible, crypto/rand doesn't fail
+	}
+	return int(i.Int64())
+}
+
+func (g *passphraseGenerator) generate() string {
+	words := g.wordList()
+	indexes := make([]int, g.nWords)
+	out := make([]string, g.nWords)
+	for i := range out {
+		indexes[i] = randInt(len(words))
+		word := words[indexes[i]]
+		if g.capitalize && randInt(2) == 1 {
+			r, n := utf8.DecodeRuneInString(word)
+			word = string(unicode.ToUpper(r)) + word[n:]
+		}
+		if g.digits {
+			word += fmt.Sprint(randInt(10))
+		}
+		out[i] = word
+	}
+	if g.checksum {
+		out = append(out, words[checksumIndex(indexes, len(words))])
+	}
+	return strings.Join(out, g.separator)
+}
+
+// readWordlist reads a word list with one word per line. Duplicate
+// words are dropped, so that every word is equally likely. If fold is
+// set, words are lowercased first, so that capitalizing a word never
+// gives another word of the list.
+func readWordlist(fileName string, fold bool) ([]string, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var words []string
+	seen := make(map[string]bool)
+	for _, word := range strings.Split(string(b), "\n") {
+		word = strings.TrimSpace(word)
+		if fold {
+			word = strings.ToLower(word)
+		}
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	if len(words) < 2 {
+		return nil, fmt.Errorf("word list %q needs at least 2 different words", fileName)
+	}
+	return words, nil
+}
+
+type genpassCmd struct {
+	bits       float64
+	nWords     int
+	separator  string
+	capitalize bool
+	digits     bool
+	wordlist   string
+
+	stdout io.Writer
+}
+
+func (*genpassCmd) Name() string     { return "genpass" }
+func (*genpassCmd) Synopsis() string { return "generate a passphrase" }
+func (*genpassCmd) Usage() string {
+	return `usage: sym genpass [OPTION]...
+Generate a passphrase of random words, and print its entropy to stderr.
+This is the same generator that sym enc -g uses. By default, the
+passphrase has 10 words, or enough words for the entropy given by
+-bits. Example:
+  sym genpass -bits 80 -sep - -capitalize
+
+`
+}
+
+func (c *genpassCmd) SetFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&c.bits, "bits", 0, "use enough words for `n` bits of entropy")
+	fs.IntVar(&c.nWords, "words", 0, "use `n` words (default 10)")
+	fs.StringVar(&c.separator, "sep", " ", "separate the words with `string`")
+	fs.BoolVar(&c.capitalize, "capitalize", false, "capitalize words at random")
+	fs.BoolVar(&c.digits, "digits", false, "add a random digit to each word")
+	fs.StringVar(&c.wordlist, "wordlist", "", "take the words from `file`, one per line")
+}
+
+func (c *genpassCmd) run(args ...string) error {
+	if len(args) > 0 {
+		return usageErr("genpass does not take any arguments")
+	}
+	if c.bits != 0 && c.nWords != 0 {
+		return usageErr("-bits and -words cannot be used together")
+	}
+	if c.bits < 0 || c.nWords < 0 || math.IsNaN(c.bits) {
+		return usageErr("-bits and -words must be positive")
+	}
+	if c.nWords > maxWords {
+		return usageErr("-words must be at most %d", maxWords)
+	}
+	g := &passphraseGenerator{
+		nWords:     c.nWords,
+		separator:  c.separator,
+		capitalize: c.capitalize,
+		digits:     c.digits,
+	}
+	if c.wordlist != "" {
+		var err error
+		if g.words, err = readWordlist(c.wordlist, c.capitalize); err != nil {
+			return err
+		}
+	}
+	switch {
+	case c.bits > 0:
+		nWords := math.Ceil(c.bits / g.wordEntropy())
+		if nWords > maxWords {
+			return usageErr("-bits %g needs more than %d words", c.bits, maxWords)
+		}
+		g.nWords = int(nWords)
+	case g.nWords == 0:
+		g.nWords = defaultWords
+	}
+	fmt.Fprintln(c.stdout, g.generate())
+	fmt.Fprintf(os.Stderr, "%d words, %.1f bits of entropy\n", g.nWords, g.entropy())
+	return nil
+}
+
+func (c *genpassCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return exitStatus(ctx, c.run(f.Args()...))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestPassphraseGenerator(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc        string
+		gen         passphraseGenerator
+		wantEntropy float64
+	}{{
+		desc:        "Default",
+		gen:         passphraseGenerator{nWords: 10, separator: " "},
+		wantEntropy: 130,
+	}, {
+		desc:        "Digits",
+		gen:         passphraseGenerator{words: []string{"a", "b", "c", "d"}, nWords: 3, separator: "-", digits: true},
+		wantEntropy: 3 * (2 + math.Log2(10)),
+	}, {
+		desc:        "Capitalize",
+		gen:         passphraseGenerator{words: []string{"a", "b", "c", "1"}, nWords: 4, separator: ".", capitalize: true},
+		wantEntropy: 4 * (2 + 0.75),
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tc.gen.entropy(); math.Abs(got-tc.wantEntropy) > 1e-9 {
+				t.Errorf("entropy() = %g, want %g", got, tc.wantEntropy)
+			}
+			words := strings.Split(tc.gen.generate(), tc.gen.separator)
+			if len(words) != tc.gen.nWords {
+				t.Fatalf("generate() returned %d words, want %d", len(words), tc.gen.nWords)
+			}
+			for _, word := range words {
+				if tc.gen.digits {
+					if last := rune(word[len(word)-1]); !unicode.IsDigit(last) {
+						t.Errorf("Word %q does not end with a digit", word)
+					}
+					word = word[:len(word)-1]
+				}
+				if tc.gen.capitalize {
+					word = strings.ToLower(word)
+				}
+				found := false
+				for _, w := range tc.gen.wordList() {
+					found = found || w == word
+				}
+				if !found {
+					t.Errorf("Word %q is not in the word list", word)
+				}
+			}
+		})
+	}
+}
+
+func TestGenpassCmd_Run(t *testing.T) {
+	t.Parallel()
+
+	wordlistFile := filepath.Join(t.TempDir(), "words")
+	mustWriteFile(t, wordlistFile, []byte("Apple\napple\nbanana\n\ncherry\ndate\n"))
+	for _, tc := range []struct {
+		desc      string
+		cmd       genpassCmd
+		wantWords int
+		wantErr   bool
+	}{{
+		desc:      "Default",
+		wantWords: 10,
+	}, {
+		desc:      "Words",
+		cmd:       genpassCmd{nWords: 4},
+		wantWords: 4,
+	}, {
+		desc:      "Bits",
+		cmd:       genpassCmd{bits: 27},
+		wantWords: 3,
+	}, {
+		desc:      "Wordlist",
+		cmd:       genpassCmd{wordlist: wordlistFile, capitalize: true, bits: 10},
+		wantWords: 4,
+	}, {
+		desc:    "BitsAndWords",
+		cmd:     genpassCmd{bits: 80, nWords: 4},
+		wantErr: true,
+	}, {
+		desc:    "TooManyWords",
+		cmd:     genpassCmd{nWords: maxWords + 1},
+		wantErr: true,
+	}, {
+		desc:    "TooManyBits",
+		cmd:     genpassCmd{bits: 1e30},
+		wantErr: true,
+	}, {
+		desc:    "InfiniteBits",
+		cmd:     genpassCmd{bits: math.Inf(1)},
+		wantErr: true,
+	}, {
+		desc:    "WordlistNotFound",
+		cmd:     genpassCmd{wordlist: filepath.Join(t.TempDir(), "nonexistent")},
+		wantErr: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			stdout := new(strings.Builder)
+			c := tc.cmd
+			c.separator = " "
+			c.stdout = stdout
+			err := c.run()
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("genpassCmd.run returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if got := len(strings.Fields(stdout.String())); got != tc.wantWords {
+				t.Errorf("genpass printed %q, want %d words", stdout, tc.wantWords)
+			}
+		})
+	}
+}
@@ -5,8 +5,9 @@ go 1.25.0
 require (
 	github.com/google/subcommands v1.2.0
 	golang.org/x/crypto v0.44.0
+	golang.org/x/sys v0.38.0
 	golang.org/x/term v0.37.0
+	golang.org/x/text v0.31.0
 	roseh.moe/pkg/wordlist v1.0.2
+	rsc.io/qr v0.2.0
 )
-
-require golang.org/x/sys v0.38.0 // indirect
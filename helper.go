@@ -0,0 +1,117 @@
+package main
+
+// Passwords can be fetched from an external helper program, similar to
+// git credential helpers. The helper is given key=value lines on stdin,
+// ending with a blank line:
+//
+//	action=get
+//	operation=decrypt
+//	file=backup.tar.enc
+//	key-id=5f1c...
+//
+// action is get when sym needs a password, or erase after decryption
+// failed with the password, so the helper can drop it from its cache.
+// After erase, an error line describes the failure. There is one file
+// line per input file, and for decryption one key-id line per input
+// file, with the hex-encoded salt from the file's header. The helper
+// prints the password as the first line of its output, so commands
+// like "pass show name" work as helpers without changes.
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// helperPrefix is prepended to helper names to find the helper program.
+const helperPrefix = "sym-password-"
+
+type passwordHelper struct {
+	cmd       string
+	operation string
+	files     []string
+}
+
+// command returns the command that runs the helper. A bare name
+// like "vault" runs the program sym-password-vault from $PATH, anything
+// else is run with the shell.
+func (h *passwordHelper) command() *exec.Cmd {
+	if !strings.ContainsAny(h.cmd, " \t/\\") {
+		return exec.Command(helperPrefix + h.cmd)
+	}
+	return exec.Command("sh", "-c", h.cmd)
+}
+
+func (h *passwordHelper) request(action string, failure error) []byte {
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "action=%s\n", action)
+	fmt.Fprintf(&req, "operation=%s\n", h.operation)
+	for _, fileName := range h.files {
+		if abs, err := filepath.Abs(fileName); err == nil {
+			fileName = abs
+		}
+		fmt.Fprintf(&req, "file=%s\n", fileName)
+	}
+	if h.operation == "decrypt" {
+		for _, fileName := range h.files {
+			if keyID := fileKeyID(fileName); keyID != "" {
+				fmt.Fprintf(&req, "key-id=%s\n", keyID)
+			}
+		}
+	}
+	if failure != nil {
+		fmt.Fprintf(&req, "error=%s\n", strings.ReplaceAll(failure.Error(), "\n", " "))
+	}
+	req.WriteString("\n")
+	return req.Bytes()
+}
+
+// fileKeyID returns the hex-encoded salt of an encrypted file, which
+// identifies the key it was encrypted with, or "" if the header can't
+// be read.
+func fileKeyID(fileName string) string {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h, err := readHeader(f)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.salt)
+}
+
+// get runs the helper and returns the password it prints.
+func (h *passwordHelper) get() (string, error) {
+	cmd := h.command()
+	cmd.Stdin = bytes.NewReader(h.request("get", nil))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("password command %q failed: %s", h.cmd, err)
+	}
+	password, err := readLine(bytes.NewReader(out))
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("password command %q printed an empty password", h.cmd)
+	}
+	return password, nil
+}
+
+// erase tells the helper that the password it returned did not work.
+func (h *passwordHelper) erase(failure error) error {
+	cmd := h.command()
+	cmd.Stdin = bytes.NewReader(h.request("erase", failure))
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("password command %q failed: %s", h.cmd, err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecCmd_Run_PasswordHelper(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	for _, tc := range []struct {
+		desc      string
+		password  string
+		exists    bool
+		archive   bool
+		patterns  []string
+		wantErr   bool
+		wantLines []string
+		// noErase is set if the password must not be erased.
+		noErase bool
+	}{{
+		desc:      "Get",
+		password:  "asdf",
+		wantLines: []string{"action=get", "operation=decrypt"},
+	}, {
+		desc:      "WrongPassword",
+		password:  "wrong",
+		wantErr:   true,
+		wantLines: []string{"action=get", "action=erase", "error="},
+	}, {
+		desc:      "OutputExists",
+		password:  "asdf",
+		exists:    true,
+		wantErr:   true,
+		wantLines: []string{"action=get"},
+		noErase:   true,
+	}, {
+		desc:      "ArchiveWrongPassword",
+		password:  "wrong",
+		archive:   true,
+		patterns:  []string{"file"},
+		wantErr:   true,
+		wantLines: []string{"action=get", "action=erase"},
+	}, {
+		desc:      "ArchiveNoMatch",
+		password:  "asdf",
+		archive:   true,
+		patterns:  []string{"nothing"},
+		wantErr:   true,
+		wantLines: []string{"action=get"},
+		noErase:   true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			fileName := filepath.Join(dir, "file")
+			input := fileName + ".enc"
+			if tc.archive {
+				fileName = filepath.Join(dir, "tree")
+				input = fileName + ".tar.enc"
+				mustMkdir(t, fileName)
+				mustWriteFile(t, filepath.Join(fileName, "file"), []byte("test file content"))
+			} else {
+				mustWriteFile(t, fileName, []byte("test file content"))
+			}
+			if err := (&encCmd{recursive: tc.archive}).encryptFile(t.Context(), fileName, passwordKey("asdf")); err != nil {
+				t.Fatalf("encryptFile failed: %s", err)
+			}
+			if !tc.exists {
+				if err := os.RemoveAll(fileName); err != nil {
+					t.Fatal(err)
+				}
+			}
+			logFile := filepath.Join(dir, "log")
+			c := &decCmd{keys: keyFlags{pwSource: passwordSource{
+				cmd: fmt.Sprintf("cat >> '%s'; echo %s", logFile, tc.password),
+			}}, patterns: tc.patterns}
+			err := c.run(t.Context(), input)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("decCmd.run returned error %v, want error? %t", err, tc.wantErr)
+			}
+			log := string(mustReadFile(t, logFile))
+			wantLines := append(tc.wantLines,
+				"file="+input,
+				"key-id="+hex.EncodeToString(mustReadHeader(t, input).salt))
+			for _, want := range wantLines {
+				if !strings.Contains(log, want) {
+					t.Errorf("Password helper input %q does not contain %q", log, want)
+				}
+			}
+			if tc.noErase && strings.Contains(log, "action=erase") {
+				t.Errorf("Password helper input %q erases the password", log)
+			}
+		})
+	}
+}
+
+func mustReadHeader(t *testing.T, fileName string) *header {
+	t.Helper()
+	h, err := readHeader(strings.NewReader(string(mustReadFile(t, fileName))))
+	if err != nil {
+		t.Fatalf("Failed to read header: %s", err)
+	}
+	return h
+}
+
+func TestCatCmd_Run_PasswordHelper(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	for _, tc := range []struct {
+		desc      string
+		password  string
+		missing   bool
+		wantErase bool
+	}{{
+		desc:      "WrongPassword",
+		password:  "wrong",
+		wantErase: true,
+	}, {
+		desc:     "NotFound",
+		password: "asdf",
+		missing:  true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			fileName := filepath.Join(dir, "file")
+			mustWriteFile(t, fileName, []byte("test file content"))
+			if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey("asdf")); err != nil {
+				t.Fatalf("encryptFile failed: %s", err)
+			}
+			input := fileName + ".enc"
+			if tc.missing {
+				input = filepath.Join(dir, "missing.enc")
+			}
+			logFile := filepath.Join(dir, "log")
+			c := &catCmd{keys: keyFlags{pwSource: passwordSource{
+				cmd: fmt.Sprintf("cat >> '%s'; echo %s", logFile, tc.password),
+			}}, stdout: io.Discard}
+			if err := c.run(t.Context(), input); err == nil {
+				t.Fatal("catCmd.run succeeded, want error")
+			}
+			log, _ := os.ReadFile(logFile)
+			if got := strings.Contains(string(log), "action=erase"); got != tc.wantErase {
+				t.Errorf("Password helper input %q erases the password: %t, want %t", log, got, tc.wantErase)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+// Keyfiles are mixed into the key, so that decrypting a file needs both
+// the password and the keyfiles, or only the keyfiles if no password
+// was used. The contents of each keyfile are hashed, and the sorted
+// hashes are hashed together, so the order of the keyfiles doesn't
+// matter. The result is combined with the key derived from the
+// password, or used alone, with HKDF.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+const (
+	keyfileSize = 64
+
+	// Info strings for deriving keys from keyfiles.
+	keyfileInfo         = "sym keyfile"
+	keyfilePasswordInfo = "sym password and keyfile"
+)
+
+// readKeyfiles hashes the contents of the keyfiles.
+func readKeyfiles(fileNames []string) ([]byte, error) {
+	var hashes [][]byte
+	for _, fileName := range fileNames {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read keyfile %q: %s", fileName, err)
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("keyfile %q is empty", fileName)
+		}
+		hashes = append(hashes, h.Sum(nil))
+	}
+	slices.SortFunc(hashes, bytes.Compare)
+	h := sha256.New()
+	h.Write([]byte(keyfileInfo))
+	for _, hash := range hashes {
+		h.Write(hash)
+	}
+	return h.Sum(nil), nil
+}
+
+type keyfileKey struct {
+	// password is the key function for the password, or nil if only
+	// keyfiles are used.
+	password keyFunc
+	secret   []byte
+}
+
+// encryptKey is the keyFunc for encrypting. It records in the header
+// how the key is derived.
+func (k *keyfileKey) encryptKey(h *header) ([]byte, error) {
+	h.keyfile = true
+	h.noPassword = k.password == nil
+	return k.derive(h)
+}
+
+// decryptKey is the keyFunc for decrypting.
+func (k *keyfileKey) decryptKey(h *header) ([]byte, error) {
+	if h.mirror {
+		return nil, errMirrorFile
+	}
+	if !h.keyfile {
+		return nil, errors.New("file was not encrypted with a keyfile")
+	}
+	return k.derive(h)
+}
+
+func (k *keyfileKey) derive(h *header) ([]byte, error) {
+	if h.noPassword {
+		return deriveKey(k.secret, h.salt, keyfileInfo), nil
+	}
+	if k.password == nil {
+		return nil, errors.New("file was encrypted with a password and a keyfile, but no password was given")
+	}
+	// Hide the keyfile flags from the password's key function.
+	ph := &header{salt: h.salt, norm: h.norm}
+	pwKey, err := k.password(ph)
+	if err != nil {
+		return nil, err
+	}
+	if ph.fallback != nil {
+		h.fallback = (&keyfileKey{password: ph.fallback, secret: k.secret}).derive
+	}
+	return deriveKey(append(pwKey, k.secret...), h.salt, keyfilePasswordInfo), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyfile(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc        string
+		encPassword string
+		encKeyfiles []string
+		noPassword  bool
+		decPassword string
+		decKeyfiles []string
+		wantErr     bool
+	}{{
+		desc:        "PasswordAndKeyfile",
+		encPassword: "asdf",
+		encKeyfiles: []string{"key1"},
+		decPassword: "asdf",
+		decKeyfiles: []string{"key1"},
+	}, {
+		desc:        "KeyfileOnly",
+		encKeyfiles: []string{"key1"},
+		noPassword:  true,
+		decKeyfiles: []string{"key1"},
+	}, {
+		desc:        "KeyfileOrder",
+		encPassword: "asdf",
+		encKeyfiles: []string{"key1", "key2"},
+		decPassword: "asdf",
+		decKeyfiles: []string{"key2", "key1"},
+	}, {
+		desc:        "WrongKeyfile",
+		encPassword: "asdf",
+		encKeyfiles: []string{"key1"},
+		decPassword: "asdf",
+		decKeyfiles: []string{"key2"},
+		wantErr:     true,
+	}, {
+		desc:        "MissingKeyfile",
+		encPassword: "asdf",
+		encKeyfiles: []string{"key1", "key2"},
+		decPassword: "asdf",
+		decKeyfiles: []string{"key1"},
+		wantErr:     true,
+	}, {
+		desc:        "NoKeyfile",
+		encPassword: "asdf",
+		encKeyfiles: []string{"key1"},
+		decPassword: "asdf",
+		wantErr:     true,
+	}, {
+		desc:        "WrongPassword",
+		encPassword: "asdf",
+		encKeyfiles: []string{"key1"},
+		decPassword: "wrong",
+		decKeyfiles: []string{"key1"},
+		wantErr:     true,
+	}, {
+		desc:        "UnexpectedKeyfile",
+		encPassword: "asdf",
+		decPassword: "asdf",
+		decKeyfiles: []string{"key1"},
+		wantErr:     true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			for _, name := range []string{"key1", "key2"} {
+				if err := (&keygenCmd{keyfile: filepath.Join(dir, name)}).run(); err != nil {
+					t.Fatalf("keygen failed: %s", err)
+				}
+			}
+			paths := func(names []string) stringsFlag {
+				var out stringsFlag
+				for _, name := range names {
+					out = append(out, filepath.Join(dir, name))
+				}
+				return out
+			}
+			fileContent := []byte("test file content")
+			fileName := filepath.Join(dir, "file")
+			mustWriteFile(t, fileName, fileContent)
+			enc := &encCmd{
+				password:   tc.encPassword,
+				keyfiles:   paths(tc.encKeyfiles),
+				noPassword: tc.noPassword,
+				allowWeak:  true,
+			}
+			if err := enc.run(t.Context(), fileName); err != nil {
+				t.Fatalf("enc failed: %s", err)
+			}
+			mustRemove(t, fileName)
+			dec := &decCmd{keys: keyFlags{
+				password: tc.decPassword,
+				keyfiles: paths(tc.decKeyfiles),
+				passwordIn: func(string) (string, error) {
+					t.Error("dec prompted for a password")
+					return "", nil
+				},
+			}}
+			err := dec.run(t.Context(), fileName+".enc")
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("dec returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if err == nil {
+				if got := mustReadFile(t, fileName); !bytes.Equal(got, fileContent) {
+					t.Errorf("Decrypted file has contents %q, want %q", got, fileContent)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// keyFlags are the flags for the key of files to decrypt, shared by
+// the subcommands that decrypt files.
+type keyFlags struct {
+	password   string
+	pwSource   passwordSource
+	agent      *agentClient
+	keyfiles   stringsFlag
+	shares     stringsFlag
+	shareFiles stringsFlag
+
+	passwordIn func(prompt string) (string, error)
+}
+
+func (k *keyFlags) setFlags(fs *flag.FlagSet, name string) {
+	fs.StringVar(&k.password, "p", "", "use the specified password; if not provided, "+name+" will prompt for a password")
+	k.pwSource.setFlags(fs)
+	fs.Var(&k.keyfiles, "keyfile", "mix the contents of `file` into the key (may be repeated)")
+	fs.Var(&k.shares, "share", "use the share `words` printed by sym enc -shares (may be repeated)")
+	fs.Var(&k.shareFiles, "share-file", "use the share in `file` written by sym enc -shares (may be repeated)")
+}
+
+// check checks that the flags can be used together.
+func (k *keyFlags) check() error {
+	nPasswords := k.pwSource.count()
+	if k.password != "" {
+		nPasswords++
+	}
+	if nPasswords > 1 {
+		return usageErr("only one of -p and the -password-* flags can be used")
+	}
+	if (len(k.shares) > 0 || len(k.shareFiles) > 0) && (nPasswords > 0 || len(k.keyfiles) > 0) {
+		return usageErr("-share cannot be used with a password or -keyfile")
+	}
+	return nil
+}
+
+// key returns the key function for decrypting files, prompting for
+// the password if needed, and a function to call once a file has been
+// decrypted with it. files are passed to the password helper.
+func (k *keyFlags) key(files []string) (key keyFunc, decrypted func(), err error) {
+	prompted := false
+	password, ok, err := k.pwSource.read("decrypt", files)
+	switch {
+	case err != nil:
+		return nil, nil, err
+	case ok:
+		// The password was read from one of the -password-* flags.
+	case k.password != "":
+		warnPasswordFlag()
+		password = k.password
+	case len(k.shares) > 0 || len(k.shareFiles) > 0:
+		shares, err := readShares(k.shares, k.shareFiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		secret, err := combineShares(shares)
+		if err != nil {
+			return nil, nil, err
+		}
+		return (&sharesKey{secret: secret}).decryptKey, func() {}, nil
+	case k.agent.unlocked():
+		// If the agent's password doesn't decrypt a file, ask for the
+		// password of the file.
+		var pwKey keyFunc
+		fallback := func(h *header) ([]byte, error) {
+			if pwKey == nil {
+				fmt.Fprintln(os.Stderr, "sym: the password held by the agent doesn't decrypt the file")
+				pw, err := k.passwordIn("Enter password: ")
+				if err != nil {
+					return nil, err
+				}
+				checkPassphrase(pw)
+				pwKey = passwordKey(pw)
+			}
+			return pwKey(h)
+		}
+		key = func(h *header) ([]byte, error) {
+			agentKey, err := k.agent.key(h)
+			if err == nil {
+				h.fallback = fallback
+			}
+			return agentKey, err
+		}
+	case len(k.keyfiles) > 0:
+		// Files encrypted with only keyfiles don't need a password, so
+		// only ask for it when a file needs one.
+		key = func(h *header) ([]byte, error) {
+			if password == "" {
+				pw, err := k.passwordIn("Enter password: ")
+				if err != nil {
+					return nil, err
+				}
+				checkPassphrase(pw)
+				password, prompted = pw, true
+			}
+			return passwordKey(password)(h)
+		}
+	default:
+		if password, err = k.passwordIn("Enter password: "); err != nil {
+			return nil, nil, err
+		}
+		prompted = true
+	}
+	if password != "" {
+		// Catch typos in generated passphrases before hashing.
+		checkPassphrase(password)
+	}
+	if key == nil {
+		key = passwordKey(password)
+	}
+	if len(k.keyfiles) > 0 {
+		secret, err := readKeyfiles(k.keyfiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		key = (&keyfileKey{password: key, secret: secret}).decryptKey
+	}
+	decrypted = func() {
+		// Only give the agent passwords that worked.
+		if prompted {
+			k.agent.remember(password)
+		}
+	}
+	return key, decrypted, nil
+}
+
+// newKey returns the key function for encrypting a new file, like enc
+// does: the password is checked and confirmed if it is read from the
+// terminal. files are passed to the password helper. -share can't be
+// used to encrypt, and must be refused by the caller.
+func (k *keyFlags) newKey(files []string, allowWeak bool) (keyFunc, error) {
+	enc := &encCmd{
+		password:   k.password,
+		pwSource:   k.pwSource,
+		agent:      k.agent,
+		allowWeak:  allowWeak,
+		blocklist:  os.Getenv(blocklistEnv),
+		passwordIn: k.passwordIn,
+	}
+	key, err := enc.passwordKey(files)
+	if err != nil {
+		return nil, err
+	}
+	if len(k.keyfiles) > 0 {
+		secret, err := readKeyfiles(k.keyfiles)
+		if err != nil {
+			return nil, err
+		}
+		key = (&keyfileKey{password: key, secret: secret}).encryptKey
+	}
+	return key, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+
+	"github.com/google/subcommands"
+)
+
+type keygenCmd struct {
+	keyfile string
+	force   bool
+}
+
+func (*keygenCmd) Name() string     { return "keygen" }
+func (*keygenCmd) Synopsis() string { return "generate a keyfile" }
+func (*keygenCmd) Usage() string {
+	return `usage: sym keygen -keyfile FILE
+Generate a keyfile with random content, for use with sym enc -keyfile
+and sym dec -keyfile. Example:
+  sym keygen -keyfile /media/usb/backup.key
+
+`
+}
+
+func (c *keygenCmd) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.keyfile, "keyfile", "", "write the keyfile to `file`")
+	fs.BoolVar(&c.force, "f", false, "overwrite the keyfile if it already exists")
+}
+
+func (c *keygenCmd) run(args ...string) error {
+	if c.keyfile == "" || len(args) > 0 {
+		return usageErr("keygen requires -keyfile and no other arguments")
+	}
+	f, err := createOutputFile(c.keyfile, c.force)
+	if err != nil {
+		return err
+	}
+	defer f.abort()
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+	key := make([]byte, keyfileSize)
+	rand.Read(key)
+	if _, err := f.Write(key); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+func (c *keygenCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return exitStatus(ctx, c.run(f.Args()...))
+}
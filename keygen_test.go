@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeygenCmd_Run(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	key1 := filepath.Join(dir, "key1")
+	key2 := filepath.Join(dir, "key2")
+	for _, fileName := range []string{key1, key2} {
+		if err := (&keygenCmd{keyfile: fileName}).run(); err != nil {
+			t.Fatalf("keygen failed: %s", err)
+		}
+	}
+	got1, got2 := mustReadFile(t, key1), mustReadFile(t, key2)
+	if len(got1) != keyfileSize {
+		t.Errorf("Keyfile has %d bytes, want %d", len(got1), keyfileSize)
+	}
+	if bytes.Equal(got1, got2) {
+		t.Error("keygen generated the same keyfile twice")
+	}
+	fi, err := os.Stat(key1)
+	if err != nil {
+		t.Fatalf("Failed to stat keyfile: %s", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("Keyfile has mode %v, want 0600", perm)
+	}
+	if err := (&keygenCmd{keyfile: key1}).run(); err == nil {
+		t.Error("keygen overwrote an existing keyfile without -f")
+	}
+}
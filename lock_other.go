@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// lockFile does nothing, since locking files is not supported on this
+// platform.
+func lockFile(f *os.File) error {
+	return nil
+}
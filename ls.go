@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+type lsCmd struct {
+	keys keyFlags
+	long bool
+	json bool
+
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func (*lsCmd) Name() string     { return "ls" }
+func (*lsCmd) Synopsis() string { return "list the contents of an encrypted archive" }
+func (*lsCmd) Usage() string {
+	return `usage: sym ls [OPTION]... [FILE]...
+List the contents of directory archives created with sym enc -r, or of
+stdin if no files are provided. Nothing is written to disk.
+
+` + passwordSourceUsage + "\n"
+}
+
+func (c *lsCmd) SetFlags(fs *flag.FlagSet) {
+	c.keys.setFlags(fs, "ls")
+	fs.BoolVar(&c.long, "l", false, "use a long listing format, showing modes, sizes and modification times")
+	fs.BoolVar(&c.json, "json", false, "print one JSON object per entry")
+}
+
+type lsEntry struct {
+	Archive  string    `json:"archive,omitempty"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Mode     string    `json:"mode"`
+	ModTime  time.Time `json:"mtime"`
+	Linkname string    `json:"linkname,omitempty"`
+}
+
+func entryType(hdr *tar.Header) string {
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	}
+	return "other"
+}
+
+func (c *lsCmd) printEntry(archive string, hdr *tar.Header) error {
+	mode := hdr.FileInfo().Mode()
+	if c.json {
+		b, err := json.Marshal(&lsEntry{
+			Archive:  archive,
+			Name:     hdr.Name,
+			Type:     entryType(hdr),
+			Size:     hdr.Size,
+			Mode:     mode.String(),
+			ModTime:  hdr.ModTime.UTC(),
+			Linkname: hdr.Linkname,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.stdout, "%s\n", b)
+		return err
+	}
+	if !c.long {
+		_, err := fmt.Fprintln(c.stdout, hdr.Name)
+		return err
+	}
+	name := hdr.Name
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		name += " -> " + hdr.Linkname
+	case tar.TypeLink:
+		name += " link to " + hdr.Linkname
+		mode &^= fs.ModeType
+	}
+	_, err := fmt.Fprintf(c.stdout, "%s %12d %s %s\n", mode, hdr.Size, hdr.ModTime.Local().Format("2006-01-02 15:04"), name)
+	return err
+}
+
+// list prints the entries of the encrypted archive in r.
+func (c *lsCmd) list(ctx context.Context, archive string, r io.Reader, key keyFunc) error {
+	reader := newKeyedDecryptingReader(ctx, r, key)
+	// Only errors from decrypting are reported to the password helper.
+	rr := &readRecorder{r: reader}
+	defer func() {
+		if rr.err != nil && ctx.Err() == nil {
+			c.keys.pwSource.reportFailure(rr.err)
+		}
+	}()
+	header, err := reader.readHeader()
+	if err != nil {
+		rr.err = err
+		return err
+	}
+	if !header.archive {
+		return errors.New("not a directory archive")
+	}
+	tr := tar.NewReader(rr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			// Read the rest of the stream, so that it gets authenticated.
+			_, err := io.Copy(io.Discard, rr)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.printEntry(archive, hdr); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *lsCmd) listFile(ctx context.Context, fileName string, key keyFunc) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := c.list(ctx, fileName, f, key); err != nil {
+		return fmt.Errorf("list %q: %s", fileName, err)
+	}
+	return nil
+}
+
+func (c *lsCmd) run(ctx context.Context, args ...string) error {
+	if c.json && c.long {
+		return usageErr("-l and -json cannot be used together")
+	}
+	if err := c.keys.check(); err != nil {
+		return err
+	}
+	key, decrypted, err := c.keys.key(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		if err := c.list(ctx, "", c.stdin, key); err != nil {
+			return err
+		}
+		decrypted()
+		return nil
+	}
+	for i, fileName := range args {
+		if len(args) > 1 && !c.json {
+			if i > 0 {
+				fmt.Fprintln(c.stdout)
+			}
+			fmt.Fprintf(c.stdout, "%s:\n", fileName)
+		}
+		if err := c.listFile(ctx, fileName, key); err != nil {
+			return err
+		}
+		decrypted()
+	}
+	return nil
+}
+
+func (c *lsCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return exitStatus(ctx, c.run(ctx, f.Args()...))
+}
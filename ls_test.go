@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLsCmd_Run(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	dir := filepath.Join(t.TempDir(), "dir")
+	mustMkdir(t, dir)
+	mustWriteFile(t, filepath.Join(dir, "file"), []byte("file content"))
+	mustSymlink(t, "file", filepath.Join(dir, "link"))
+	if err := (&encCmd{recursive: true}).encryptFile(t.Context(), dir, passwordKey(password)); err != nil {
+		t.Fatalf("encryptFile failed: %s", err)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		cmd  lsCmd
+		want []string
+	}{{
+		desc: "Short",
+		want: []string{"file", "link"},
+	}, {
+		desc: "Long",
+		cmd:  lsCmd{long: true},
+		want: []string{
+			"-rw------- " + strings.Repeat(" ", 10) + "12 ",
+			"link -> file",
+		},
+	}, {
+		desc: "JSON",
+		cmd:  lsCmd{json: true},
+		want: []string{`"name":"file","type":"file","size":12,"mode":"-rw-------"`},
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			stdout := new(strings.Builder)
+			c := tc.cmd
+			c.keys.password = password
+			c.stdout = stdout
+			if err := c.run(t.Context(), dir+".tar.enc"); err != nil {
+				t.Fatalf("lsCmd.run failed: %s", err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("ls output %q does not contain %q", stdout, want)
+				}
+			}
+			if c.json {
+				for line := range strings.Lines(stdout.String()) {
+					if !json.Valid([]byte(line)) {
+						t.Errorf("ls output line %q is not valid JSON", line)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLsCmd_Run_NotArchive(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	fileName := filepath.Join(t.TempDir(), "file")
+	mustWriteFile(t, fileName, []byte("file content"))
+	if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
+		t.Fatalf("encryptFile failed: %s", err)
+	}
+	if err := (&lsCmd{keys: keyFlags{password: password}, stdout: new(strings.Builder)}).run(t.Context(), fileName+".enc"); err == nil {
+		t.Error("ls succeeded for a file that is not an archive, want error")
+	}
+}
+
+func TestLsCmd_Run_Keyfile(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	dir := filepath.Join(t.TempDir(), "dir")
+	keyfile := filepath.Join(t.TempDir(), "key")
+	mustMkdir(t, dir)
+	mustWriteFile(t, filepath.Join(dir, "file"), []byte("file content"))
+	mustWriteFile(t, keyfile, []byte("keyfile contents"))
+	if err := (&encCmd{password: password, allowWeak: true, recursive: true, keyfiles: stringsFlag{keyfile}}).run(t.Context(), dir); err != nil {
+		t.Fatalf("enc failed: %s", err)
+	}
+	stdout := new(strings.Builder)
+	c := &lsCmd{keys: keyFlags{password: password, keyfiles: stringsFlag{keyfile}}, stdout: stdout}
+	if err := c.run(t.Context(), dir+".tar.enc"); err != nil {
+		t.Fatalf("lsCmd.run failed: %s", err)
+	}
+	if got := stdout.String(); got != "file\n" {
+		t.Errorf("ls printed %q, want %q", got, "file\n")
+	}
+}
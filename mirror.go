@@ -0,0 +1,324 @@
+package main
+
+// Mirror mode encrypts a directory tree into another tree, with one
+// encrypted file per plaintext file. The root of the encrypted tree
+// contains mirrorKeyFile, which holds a random master key encrypted
+// with the password. Each file is encrypted with a key derived from the
+// master key and the file's own salt, so the password only needs to be
+// hashed once per tree.
+//
+// Each path component is encrypted deterministically, so the same
+// plaintext path always maps to the same encrypted path. Names are
+// encrypted with a SIV-style construction: an HMAC of the parent path
+// and the name is used both as the authentication tag and as the nonce
+// for encrypting the name with ChaCha20. Names are padded to a multiple
+// of namePadding bytes to hide their exact length, and encoded with
+// lowercase base32 so they are safe on case-insensitive filesystems.
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	mirrorKeyFile = ".sym-mirror"
+	masterKeySize = 32
+
+	nameTagSize    = 16
+	namePadding    = 16
+	maxNameLength  = 255
+	maxPaddedName  = maxNameLength*5/8 - nameTagSize
+	maxPlainLength = maxPaddedName/namePadding*namePadding - 1
+)
+
+var nameEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+type mirror struct {
+	fileKey    []byte
+	nameMACKey []byte
+	nameEncKey []byte
+}
+
+func newMirror(masterKey []byte) *mirror {
+	return &mirror{
+		fileKey:    deriveKey(masterKey, nil, "sym mirror file"),
+		nameMACKey: deriveKey(masterKey, nil, "sym mirror name mac"),
+		nameEncKey: deriveKey(masterKey, nil, "sym mirror name encryption"),
+	}
+}
+
+// openMirror reads the master key of the encrypted tree rooted at dir.
+// If create is set and dir is not an encrypted tree yet, a new master
+// key is generated. The master key is encrypted with key.
+func openMirror(ctx context.Context, dir string, key keyFunc, create bool) (*mirror, error) {
+	keyFile := filepath.Join(dir, mirrorKeyFile)
+	f, err := os.Open(keyFile)
+	if err == nil {
+		defer f.Close()
+		masterKey, err := io.ReadAll(newKeyedDecryptingReader(ctx, f, key))
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %s", keyFile, err)
+		}
+		if len(masterKey) != masterKeySize {
+			return nil, fmt.Errorf("read %q: malformed master key", keyFile)
+		}
+		return newMirror(masterKey), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if !create {
+		return nil, fmt.Errorf("%q is not an encrypted tree (%s not found)", dir, mirrorKeyFile)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	masterKey := make([]byte, masterKeySize)
+	rand.Read(masterKey)
+	encrypted := new(bytes.Buffer)
+	w := newKeyedEncryptingWriter(ctx, encrypted, key)
+	if _, err := w.Write(masterKey); err != nil {
+		return nil, err
+	}
+	if err := w.close(); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(keyFile, encrypted.Bytes()); err != nil {
+		return nil, err
+	}
+	return newMirror(masterKey), nil
+}
+
+func (m *mirror) key(h *header) ([]byte, error) {
+	if !h.mirror {
+		return nil, errors.New("file is not part of a mirrored tree")
+	}
+	return deriveKey(m.fileKey, h.salt, "sym mirror file"), nil
+}
+
+func (m *mirror) nameTag(parent string, padded []byte) []byte {
+	mac := hmac.New(sha256.New, m.nameMACKey)
+	mac.Write([]byte(parent))
+	mac.Write([]byte{0})
+	mac.Write(padded)
+	return mac.Sum(nil)[:nameTagSize]
+}
+
+func (m *mirror) nameCipher(tag []byte) *chacha20.Cipher {
+	c, err := chacha20.NewUnauthenticatedCipher(m.nameEncKey, tag[:chacha20.NonceSize])
+	if err != nil {
+		panic(err) // impossible, the key and nonce have the right size
+	}
+	return c
+}
+
+// encryptName encrypts a single path component. parent is the
+// slash-separated plaintext path of the directory containing it.
+func (m *mirror) encryptName(parent, name string) (string, error) {
+	if len(name) > maxPlainLength {
+		return "", fmt.Errorf("name %q is too long to encrypt", name)
+	}
+	padLen := namePadding - len(name)%namePadding
+	padded := append([]byte(name), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+	tag := m.nameTag(parent, padded)
+	out := append(tag, make([]byte, len(padded))...)
+	m.nameCipher(tag).XORKeyStream(out[nameTagSize:], padded)
+	return nameEncoding.EncodeToString(out), nil
+}
+
+// decryptName reverses encryptName.
+func (m *mirror) decryptName(parent, encName string) (string, error) {
+	errBadName := fmt.Errorf("cannot decrypt name %q", encName)
+	b, err := nameEncoding.DecodeString(encName)
+	if err != nil || len(b) < nameTagSize+namePadding || (len(b)-nameTagSize)%namePadding != 0 {
+		return "", errBadName
+	}
+	tag, padded := b[:nameTagSize], b[nameTagSize:]
+	m.nameCipher(tag).XORKeyStream(padded, padded)
+	if !hmac.Equal(tag, m.nameTag(parent, padded)) {
+		return "", errBadName
+	}
+	padLen := int(padded[len(padded)-1])
+	if padLen == 0 || padLen > namePadding {
+		return "", errBadName
+	}
+	name := string(padded[:len(padded)-padLen])
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", errBadName
+	}
+	return name, nil
+}
+
+// encryptPath encrypts each component of the slash-separated path p.
+func (m *mirror) encryptPath(p string) (string, error) {
+	var parent string
+	var out []string
+	for name := range strings.SplitSeq(p, "/") {
+		encName, err := m.encryptName(parent, name)
+		if err != nil {
+			return "", err
+		}
+		out = append(out, encName)
+		parent = path.Join(parent, name)
+	}
+	return path.Join(out...), nil
+}
+
+// decryptPath reverses encryptPath.
+func (m *mirror) decryptPath(p string) (string, error) {
+	var parent string
+	for encName := range strings.SplitSeq(p, "/") {
+		name, err := m.decryptName(parent, encName)
+		if err != nil {
+			return "", err
+		}
+		parent = path.Join(parent, name)
+	}
+	return parent, nil
+}
+
+// encryptFile encrypts the file src to dst. If h is not nil, the
+// plaintext is also written to h.
+func (m *mirror) encryptFile(ctx context.Context, src, dst string, force bool, h hash.Hash) (err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fOut, err := createOutputFile(dst, force)
+	if err != nil {
+		return err
+	}
+	defer fOut.abort()
+	var r io.Reader = f
+	if h != nil {
+		r = io.TeeReader(f, h)
+	}
+	w := newKeyedEncryptingWriter(ctx, fOut, m.key)
+	w.header.mirror = true
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("encrypt %q: %s", src, err)
+	}
+	if err := w.close(); err != nil {
+		return fmt.Errorf("encrypt %q: %s", src, err)
+	}
+	if err := fOut.commit(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+func (m *mirror) decryptFile(ctx context.Context, src, dst string, force bool) (err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fOut, err := createOutputFile(dst, force)
+	if err != nil {
+		return err
+	}
+	defer fOut.abort()
+	if _, err := io.Copy(fOut, newKeyedDecryptingReader(ctx, f, m.key)); err != nil {
+		return fmt.Errorf("decrypt %q: %s", src, err)
+	}
+	if err := fOut.commit(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+// walkMirror calls fn for every directory and regular file under src,
+// with its slash-separated path relative to src. Other file types are
+// skipped, as is skipDir if it is inside src.
+func walkMirror(src string, skipDir string, fn func(rel string, d fs.DirEntry) error) error {
+	skip, _ := os.Stat(skipDir)
+	return filepath.WalkDir(src, func(fileName string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, fileName)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && skip != nil {
+			if fi, err := d.Info(); err == nil && os.SameFile(fi, skip) {
+				return filepath.SkipDir
+			}
+		}
+		if rel == "." || !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		return fn(filepath.ToSlash(rel), d)
+	})
+}
+
+// encryptTree encrypts every file under src into the encrypted tree dst.
+func encryptTree(ctx context.Context, src, dst string, key keyFunc, force bool) error {
+	m, err := openMirror(ctx, dst, key, true)
+	if err != nil {
+		return err
+	}
+	return walkMirror(src, dst, func(rel string, d fs.DirEntry) error {
+		encRel, err := m.encryptPath(rel)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(dst, filepath.FromSlash(encRel))
+		if d.IsDir() {
+			if err := os.Mkdir(out, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+				return err
+			}
+			return nil
+		}
+		return m.encryptFile(ctx, filepath.Join(src, filepath.FromSlash(rel)), out, force, nil)
+	})
+}
+
+// decryptTree decrypts the encrypted tree src, opened with openMirror,
+// into dst.
+func (m *mirror) decryptTree(ctx context.Context, src, dst string, force bool) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	return walkMirror(src, dst, func(encRel string, d fs.DirEntry) error {
+		if encRel == mirrorKeyFile || encRel == mirrorStateFile {
+			return nil
+		}
+		rel, err := m.decryptPath(encRel)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(dst, filepath.FromSlash(rel))
+		if d.IsDir() {
+			if err := os.Mkdir(out, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+				return err
+			}
+			return nil
+		}
+		return m.decryptFile(ctx, filepath.Join(src, filepath.FromSlash(encRel)), out, force)
+	})
+}
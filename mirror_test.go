@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	restored := filepath.Join(t.TempDir(), "restored")
+	mustMkdir(t, src)
+	mustMkdir(t, filepath.Join(src, "secret-dir"))
+	mustWriteFile(t, filepath.Join(src, "secret-dir", "secret-file"), []byte("file content"))
+	mustWriteFile(t, filepath.Join(src, "top"), []byte("top content"))
+
+	if err := (&encCmd{mirror: true, password: password, allowWeak: true}).run(t.Context(), src, dst); err != nil {
+		t.Fatalf("enc -mirror failed: %s", err)
+	}
+	nFiles := 0
+	filepath.WalkDir(dst, func(fileName string, d fs.DirEntry, err error) error {
+		if strings.Contains(fileName, "secret") || strings.Contains(fileName, "top") {
+			t.Errorf("Encrypted tree contains plaintext name %q", fileName)
+		}
+		if d.Type().IsRegular() {
+			nFiles++
+		}
+		return nil
+	})
+	if nFiles != 3 {
+		t.Errorf("Encrypted tree has %d files, want 3", nFiles)
+	}
+
+	if err := (&decCmd{mirror: true, keys: keyFlags{password: password}}).run(t.Context(), dst, restored); err != nil {
+		t.Fatalf("dec -mirror failed: %s", err)
+	}
+	if got := mustReadFile(t, filepath.Join(restored, "secret-dir", "secret-file")); string(got) != "file content" {
+		t.Errorf("Restored file has content %q, want %q", got, "file content")
+	}
+	if got := mustReadFile(t, filepath.Join(restored, "top")); string(got) != "top content" {
+		t.Errorf("Restored file has content %q, want %q", got, "top content")
+	}
+
+	if err := (&decCmd{mirror: true, keys: keyFlags{password: "wrong"}}).run(t.Context(), dst, filepath.Join(t.TempDir(), "out")); err == nil {
+		t.Error("dec -mirror succeeded with wrong password, want error")
+	}
+}
+
+func TestMirror_EncryptName(t *testing.T) {
+	t.Parallel()
+
+	m := newMirror(make([]byte, masterKeySize))
+	for _, tc := range []struct {
+		parent, name string
+	}{
+		{"", "file"},
+		{"", strings.Repeat("x", 16)},
+		{"a/b", "file"},
+		{"", strings.Repeat("x", maxPlainLength)},
+	} {
+		encName, err := m.encryptName(tc.parent, tc.name)
+		if err != nil {
+			t.Fatalf("encryptName(%q, %q) failed: %s", tc.parent, tc.name, err)
+		}
+		if len(encName) > maxNameLength {
+			t.Errorf("encryptName(%q, %q) returned %d byte name, want at most %d", tc.parent, tc.name, len(encName), maxNameLength)
+		}
+		if again, _ := m.encryptName(tc.parent, tc.name); again != encName {
+			t.Errorf("encryptName(%q, %q) is not deterministic", tc.parent, tc.name)
+		}
+		name, err := m.decryptName(tc.parent, encName)
+		if err != nil || name != tc.name {
+			t.Errorf("decryptName(%q, %q) = %q, %v, want %q", tc.parent, encName, name, err, tc.name)
+		}
+		if _, err := m.decryptName("other", encName); err == nil {
+			t.Errorf("decryptName succeeded with the wrong parent, want error")
+		}
+	}
+	if _, err := m.encryptName("", strings.Repeat("x", maxPlainLength+1)); err == nil {
+		t.Error("encryptName succeeded for a name that is too long, want error")
+	}
+}
+
+func TestDecryptFile_Mirrored(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	mustMkdir(t, src)
+	mustWriteFile(t, filepath.Join(src, "file"), []byte("file content"))
+	if err := encryptTree(t.Context(), src, dst, passwordKey(password), false); err != nil {
+		t.Fatalf("encryptTree failed: %s", err)
+	}
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		t.Fatalf("Failed to read encrypted tree: %s", err)
+	}
+	for _, e := range entries {
+		if e.Name() == mirrorKeyFile {
+			continue
+		}
+		if err := (&decCmd{}).decryptFile(t.Context(), filepath.Join(dst, e.Name()), passwordKey(password)); err == nil {
+			t.Errorf("decryptFile succeeded for a file from a mirrored tree, want error")
+		}
+	}
+}
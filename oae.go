@@ -6,18 +6,37 @@ package main
 // to encrypt each segment. The nonce is a 12 byte value, the first 11
 // bytes of which are a counter that gets incremented for each segment,
 // and the last byte is 0 for every segment except the last segment
-// (where it is 1). Before the encrypted segments, it writes a 32 byte
-// header containing the salt for the password hash.
+// (where it is 1). Before the encrypted segments, it writes a header
+// containing the salt for the password hash.
+//
+// Older files have a bare 32 byte salt as the header. Newer files start
+// with headerMagic, followed by a 2 byte length and a list of
+// tag-length-value fields. The encoded header is passed as additional
+// data for every segment, so tampering with it is detected.
+//
+// Passwords are normalized to NFC before hashing, and the header records
+// this. Files without the record were hashed from the raw password; for
+// those, the keys for the normalized forms are tried if the first
+// segment doesn't decrypt.
+//
+// If the name flag is set, the plaintext starts with the original name
+// of the file as a 2 byte length followed by the name, so that the name
+// is encrypted along with the content.
 //
 // [Online Authenticated-Encryption and its Nonce-Reuse Misuse-Resistance]: https://eprint.iacr.org/2015/189.pdf
 
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"slices"
 
 	"golang.org/x/crypto/chacha20poly1305"
 )
@@ -30,19 +49,280 @@ const (
 	plaintextSegmentSize = segmentSize - aeadOverhead
 
 	saltSize = 32
+
+	headerMagic = "\x00sym\x00v2\r\n"
+)
+
+// Header field tags.
+const (
+	fieldSalt = iota + 1
+	fieldFlags
+	fieldNorm
+)
+
+// Password normalization forms. New files record normNFC. normNone
+// means the raw password was hashed, as in older files, and normNFD is
+// only tried when decrypting those.
+const (
+	normNone = iota
+	normNFC
+	normNFD
 )
 
+// Header flags.
+const (
+	flagArchive    = 1 << iota // the plaintext is a tar archive
+	flagMirror                 // the key is derived from a mirror's master key
+	flagName                   // the plaintext starts with the original file name
+	flagKeyfile                // keyfiles are mixed into the key
+	flagNoPassword             // the key is derived from keyfiles only
+	flagShares                 // the key is derived from a secret split into shares
+
+	knownFlags = flagArchive | flagMirror | flagName | flagKeyfile | flagNoPassword | flagShares
+)
+
+var (
+	errMalformedHeader = errors.New("malformed header")
+	errMirrorFile      = errors.New("file is part of a mirrored tree (use -mirror to decrypt the whole tree)")
+	errKeyfileRequired = errors.New("file was encrypted with a keyfile (use -keyfile)")
+	errSharesRequired  = errors.New("file was encrypted with shares (use -share)")
+)
+
+type header struct {
+	salt       []byte
+	archive    bool
+	mirror     bool
+	name       bool
+	keyfile    bool
+	noPassword bool
+	shares     bool
+	norm       byte // how the password is normalized
+
+	// raw is the encoded header, or nil for legacy files.
+	raw []byte
+
+	// fallback is set by a key function whose key may be wrong, like
+	// the agent's, to the key function to try if the file doesn't
+	// decrypt with it. fallbackUsed is set if the file decrypted with
+	// the fallback.
+	fallback     keyFunc
+	fallbackUsed bool
+}
+
+func appendField(b []byte, tag byte, value []byte) []byte {
+	b = append(b, tag)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+// size returns the size of the encoded header.
+func (h *header) size() int64 {
+	if h.raw == nil {
+		return saltSize
+	}
+	return int64(len(h.raw))
+}
+
+func (h *header) marshal() []byte {
+	var body []byte
+	body = appendField(body, fieldSalt, h.salt)
+	var flags byte
+	if h.archive {
+		flags |= flagArchive
+	}
+	if h.mirror {
+		flags |= flagMirror
+	}
+	if h.name {
+		flags |= flagName
+	}
+	if h.keyfile {
+		flags |= flagKeyfile
+	}
+	if h.noPassword {
+		flags |= flagNoPassword
+	}
+	if h.shares {
+		flags |= flagShares
+	}
+	if flags != 0 {
+		body = appendField(body, fieldFlags, []byte{flags})
+	}
+	if h.norm != normNone {
+		body = appendField(body, fieldNorm, []byte{h.norm})
+	}
+	b := []byte(headerMagic)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+func (h *header) parseField(tag byte, value []byte) error {
+	switch tag {
+	case fieldSalt:
+		h.salt = value
+	case fieldFlags:
+		if len(value) != 1 || value[0]&^knownFlags != 0 {
+			return errMalformedHeader
+		}
+		h.archive = value[0]&flagArchive != 0
+		h.mirror = value[0]&flagMirror != 0
+		h.name = value[0]&flagName != 0
+		h.keyfile = value[0]&flagKeyfile != 0
+		h.noPassword = value[0]&flagNoPassword != 0
+		h.shares = value[0]&flagShares != 0
+	case fieldNorm:
+		if len(value) != 1 {
+			return errMalformedHeader
+		}
+		if value[0] != normNFC {
+			return fmt.Errorf("unsupported password normalization %d", value[0])
+		}
+		h.norm = value[0]
+	default:
+		return fmt.Errorf("unsupported header field %d", tag)
+	}
+	return nil
+}
+
+func readHeader(r io.Reader) (*header, error) {
+	magic := make([]byte, len(headerMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != headerMagic {
+		// Legacy header, just the salt.
+		salt := make([]byte, saltSize)
+		copy(salt, magic)
+		if _, err := io.ReadFull(r, salt[len(magic):]); err != nil {
+			return nil, err
+		}
+		return &header{salt: salt}, nil
+	}
+	raw := make([]byte, len(headerMagic)+2)
+	copy(raw, magic)
+	if _, err := io.ReadFull(r, raw[len(magic):]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(raw[len(magic):]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	h := &header{raw: append(raw, body...)}
+	for len(body) > 0 {
+		if len(body) < 3 {
+			return nil, errMalformedHeader
+		}
+		tag, n := body[0], int(binary.BigEndian.Uint16(body[1:]))
+		body = body[3:]
+		if len(body) < n {
+			return nil, errMalformedHeader
+		}
+		if err := h.parseField(tag, body[:n]); err != nil {
+			return nil, err
+		}
+		body = body[n:]
+	}
+	if len(h.salt) != saltSize {
+		return nil, errMalformedHeader
+	}
+	return h, nil
+}
+
+// keyFunc returns the encryption key for a file with the given header.
+// When encrypting, it is called before the header is encoded, and may
+// set flags that record how the key is derived.
+type keyFunc func(h *header) ([]byte, error)
+
+// sameKeyAs returns a keyFunc for encrypting a file again with key,
+// which decrypted the file with header h. The key is derived like it
+// was derived for h, with the new salt.
+func sameKeyAs(h *header, key keyFunc) keyFunc {
+	if h.fallbackUsed {
+		key = h.fallback
+	}
+	return func(nh *header) ([]byte, error) {
+		nh.keyfile, nh.noPassword, nh.shares = h.keyfile, h.noPassword, h.shares
+		return key(nh)
+	}
+}
+
+func passwordKey(password string) keyFunc {
+	// Remember the last key, so that trying a normalization form that
+	// doesn't change the password doesn't hash it again.
+	var lastPassword string
+	var lastSalt, lastKey []byte
+	return func(h *header) ([]byte, error) {
+		if h.mirror {
+			return nil, errMirrorFile
+		}
+		if h.keyfile {
+			return nil, errKeyfileRequired
+		}
+		if h.shares {
+			return nil, errSharesRequired
+		}
+		pw := normalizePassword(password, h.norm)
+		if lastKey == nil || pw != lastPassword || !bytes.Equal(h.salt, lastSalt) {
+			lastPassword, lastSalt = pw, h.salt
+			lastKey = hashPassword(pw, h.salt)
+		}
+		return lastKey, nil
+	}
+}
+
 type segmentEncrypter struct {
-	password string
+	key keyFunc
 
 	aead  cipher.AEAD
 	nonce [nonceSize]byte
+	ad    []byte
+
+	// retry holds the keys to try if the first segment doesn't
+	// decrypt: those for the other normalization forms of a file that
+	// doesn't record one, and those of the header's fallback. tried
+	// holds the keys that were already tried.
+	retry []retryKey
+	tried [][]byte
+}
+
+// retryKey is a key to try for the first segment. It is the key that
+// key returns for h.
+type retryKey struct {
+	key keyFunc
+	h   *header
+	// fallback is the header that key is the fallback of, if any.
+	fallback *header
+}
+
+func (se *segmentEncrypter) initialize(h *header) error {
+	key, err := se.key(h)
+	if err != nil {
+		return err
+	}
+	headers := []*header{h}
+	if h.norm == normNone {
+		for _, form := range []byte{normNFC, normNFD} {
+			retry := *h
+			retry.norm = form
+			headers = append(headers, &retry)
+		}
+	}
+	for _, rh := range headers[1:] {
+		se.retry = append(se.retry, retryKey{key: se.key, h: rh})
+	}
+	if h.fallback != nil {
+		for _, rh := range headers {
+			se.retry = append(se.retry, retryKey{key: h.fallback, h: rh, fallback: h})
+		}
+	}
+	se.tried = [][]byte{key}
+	return se.setKey(key, h.raw)
 }
 
-func (se *segmentEncrypter) initialize(salt []byte) error {
-	key := hashPassword(se.password, salt)
+func (se *segmentEncrypter) setKey(key, ad []byte) error {
 	var err error
 	se.aead, err = chacha20poly1305.New(key)
+	se.ad = ad
 	return err
 }
 
@@ -62,28 +342,85 @@ func (se *segmentEncrypter) nextNonce(lastSegment bool) {
 	}
 }
 
+// setNonce sets the nonce for the segment with the given index, for
+// random access to segments.
+func (se *segmentEncrypter) setNonce(index uint64, lastSegment bool) {
+	clear(se.nonce[:])
+	binary.LittleEndian.PutUint64(se.nonce[:], index+1)
+	if lastSegment {
+		se.nonce[len(se.nonce)-1] = 1
+	}
+}
+
 func (se *segmentEncrypter) encrypt(out, buf []byte, lastSegment bool) []byte {
 	se.nextNonce(lastSegment)
-	return se.aead.Seal(out, se.nonce[:], buf, nil)
+	return se.aead.Seal(out, se.nonce[:], buf, se.ad)
 }
 
 func (se *segmentEncrypter) decrypt(out, buf []byte, lastSegment bool) ([]byte, error) {
 	se.nextNonce(lastSegment)
-	return se.aead.Open(out, se.nonce[:], buf, nil)
+	return se.open(out, buf)
+}
+
+// open decrypts a segment with the current nonce. For the first segment
+// of a file that doesn't record how the password was normalized, the
+// keys for the other normalization forms are tried as well, and then
+// those of the fallback key function, if the key function set one.
+func (se *segmentEncrypter) open(out, buf []byte) ([]byte, error) {
+	if len(se.retry) == 0 {
+		return se.aead.Open(out, se.nonce[:], buf, se.ad)
+	}
+	retry, tried := se.retry, se.tried
+	se.retry, se.tried = nil, nil
+	// A failed Open may overwrite buf, so keep the ciphertext.
+	ciphertext := bytes.Clone(buf)
+	plaintext, err := se.aead.Open(out, se.nonce[:], buf, se.ad)
+	for _, r := range retry {
+		if err == nil {
+			break
+		}
+		key, keyErr := r.key(r.h)
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		if slices.ContainsFunc(tried, func(k []byte) bool { return bytes.Equal(k, key) }) {
+			continue
+		}
+		tried = append(tried, key)
+		if err := se.setKey(key, se.ad); err != nil {
+			return nil, err
+		}
+		plaintext, err = se.aead.Open(out, se.nonce[:], ciphertext, se.ad)
+		if err == nil && r.fallback != nil {
+			r.fallback.fallbackUsed = true
+		}
+	}
+	return plaintext, err
 }
 
 type encryptingWriter struct {
+	ctx         context.Context
 	w           io.Writer
 	encrypter   segmentEncrypter
+	header      header
 	buf         []byte
 	initialized bool
+
+	// name is the original file name. If it is set, it is stored at the
+	// start of the plaintext.
+	name string
+}
+
+func newEncryptingWriter(ctx context.Context, w io.Writer, password string) *encryptingWriter {
+	return newKeyedEncryptingWriter(ctx, w, passwordKey(password))
 }
 
-func newEncryptingWriter(w io.Writer, password string) *encryptingWriter {
+func newKeyedEncryptingWriter(ctx context.Context, w io.Writer, key keyFunc) *encryptingWriter {
 	return &encryptingWriter{
-		w: w,
+		ctx: ctx,
+		w:   w,
 		encrypter: segmentEncrypter{
-			password: password,
+			key: key,
 		},
 	}
 }
@@ -92,20 +429,39 @@ func (w *encryptingWriter) initialize() error {
 	if w.initialized {
 		return nil
 	}
-	header := make([]byte, saltSize)
-	rand.Read(header)
-	if err := w.encrypter.initialize(header); err != nil {
+	if len(w.name) > math.MaxUint16 {
+		return fmt.Errorf("file name %q is too long", w.name)
+	}
+	w.header.salt = make([]byte, saltSize)
+	rand.Read(w.header.salt)
+	w.header.name = w.name != ""
+	w.header.norm = normNFC
+	// The key function may set header flags, so it has to be called
+	// before the header is encoded.
+	key, err := w.encrypter.key(&w.header)
+	if err != nil {
+		return err
+	}
+	w.header.raw = w.header.marshal()
+	if err := w.encrypter.setKey(key, w.header.raw); err != nil {
 		return err
 	}
-	if _, err := w.w.Write(header); err != nil {
+	if _, err := w.w.Write(w.header.raw); err != nil {
 		return err
 	}
 	w.buf = make([]byte, 0, segmentSize)
+	if w.header.name {
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(len(w.name)))
+		w.buf = append(w.buf, w.name...)
+	}
 	w.initialized = true
 	return nil
 }
 
 func (w *encryptingWriter) writeBuf(lastSegment bool) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
 	if _, err := w.w.Write(w.encrypter.encrypt(w.buf[:0], w.buf, lastSegment)); err != nil {
 		return err
 	}
@@ -165,18 +521,28 @@ func (w *encryptingWriter) ReadFrom(r io.Reader) (int64, error) {
 }
 
 type decryptingReader struct {
+	ctx            context.Context
 	r              *bufio.Reader
 	decrypter      segmentEncrypter
+	header         *header
 	buf            bytes.Buffer
 	initialized    bool
 	readFinalBlock bool
+
+	// name is the original file name, if it was stored.
+	name string
+}
+
+func newDecryptingReader(ctx context.Context, r io.Reader, password string) *decryptingReader {
+	return newKeyedDecryptingReader(ctx, r, passwordKey(password))
 }
 
-func newDecryptingReader(r io.Reader, password string) *decryptingReader {
+func newKeyedDecryptingReader(ctx context.Context, r io.Reader, key keyFunc) *decryptingReader {
 	return &decryptingReader{
-		r: bufio.NewReaderSize(r, 0), // we only need .UnreadByte
+		ctx: ctx,
+		r:   bufio.NewReaderSize(r, 0), // we only need .UnreadByte
 		decrypter: segmentEncrypter{
-			password: password,
+			key: key,
 		},
 	}
 }
@@ -185,8 +551,8 @@ func (r *decryptingReader) initialize() error {
 	if r.initialized {
 		return nil
 	}
-	header := make([]byte, saltSize)
-	if _, err := io.ReadFull(r.r, header); err != nil {
+	header, err := readHeader(r.r)
+	if err != nil {
 		if err == io.EOF {
 			return io.ErrUnexpectedEOF
 		}
@@ -195,12 +561,41 @@ func (r *decryptingReader) initialize() error {
 	if err := r.decrypter.initialize(header); err != nil {
 		return err
 	}
+	r.header = header
 	r.buf = *bytes.NewBuffer(make([]byte, 0, segmentSize+1))
 	r.initialized = true
+	if header.name {
+		return r.readName()
+	}
 	return nil
 }
 
+func (r *decryptingReader) readName() error {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return err
+	}
+	name := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, name); err != nil {
+		return err
+	}
+	r.name = string(name)
+	return nil
+}
+
+// readHeader reads the file header, if it has not been read already,
+// and returns it. If the file has a stored name, it is read as well.
+func (r *decryptingReader) readHeader() (*header, error) {
+	if err := r.initialize(); err != nil {
+		return nil, err
+	}
+	return r.header, nil
+}
+
 func (r *decryptingReader) fillBuf() error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
 	r.buf.Reset()
 	// Read 1 extra byte to make sure if we're at EOF.
 	buf := r.buf.AvailableBuffer()[:segmentSize+1]
@@ -257,3 +652,96 @@ func (r *decryptingReader) WriteTo(w io.Writer) (int64, error) {
 		}
 	}
 }
+
+// segmentReaderAt provides random access to the plaintext of an
+// encrypted file by decrypting individual segments on demand. It is not
+// safe for concurrent use.
+type segmentReaderAt struct {
+	ctx        context.Context
+	r          io.ReaderAt
+	decrypter  segmentEncrypter
+	headerSize int64
+	size       int64 // size of the encrypted file
+	nSegments  int64
+
+	segment int64 // index of the segment in buf, or -1
+	buf     []byte
+}
+
+// newSegmentReaderAt returns a segmentReaderAt for the encrypted file
+// ra, whose header has been read by d.
+func newSegmentReaderAt(ra io.ReaderAt, size int64, d *decryptingReader) (*segmentReaderAt, error) {
+	header, err := d.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if header.name {
+		return nil, errors.New("random access is not supported for files with a stored name")
+	}
+	n := size - header.size()
+	nSegments := (n + segmentSize - 1) / segmentSize
+	if nSegments == 0 || n-(nSegments-1)*segmentSize < aeadOverhead {
+		return nil, errors.New("premature EOF")
+	}
+	return &segmentReaderAt{
+		ctx:        d.ctx,
+		r:          ra,
+		decrypter:  d.decrypter,
+		headerSize: header.size(),
+		size:       size,
+		nSegments:  nSegments,
+		segment:    -1,
+	}, nil
+}
+
+// Size returns the size of the plaintext.
+func (r *segmentReaderAt) Size() int64 {
+	return r.size - r.headerSize - r.nSegments*aeadOverhead
+}
+
+func (r *segmentReaderAt) loadSegment(i int64) error {
+	if r.segment == i {
+		return nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	r.segment = -1
+	off := r.headerSize + i*segmentSize
+	if cap(r.buf) < segmentSize {
+		r.buf = make([]byte, segmentSize)
+	}
+	buf := r.buf[:min(segmentSize, r.size-off)]
+	if _, err := r.r.ReadAt(buf, off); err != nil && err != io.EOF {
+		return err
+	}
+	r.decrypter.setNonce(uint64(i), i == r.nSegments-1)
+	buf, err := r.decrypter.open(buf[:0], buf)
+	if err != nil {
+		return err
+	}
+	r.buf = buf
+	r.segment = i
+	return nil
+}
+
+func (r *segmentReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	nn := 0
+	for len(buf) > 0 {
+		if off >= r.Size() {
+			return nn, io.EOF
+		}
+		i := off / plaintextSegmentSize
+		if err := r.loadSegment(i); err != nil {
+			return nn, err
+		}
+		n := copy(buf, r.buf[off-i*plaintextSegmentSize:])
+		nn += n
+		off += int64(n)
+		buf = buf[n:]
+	}
+	return nn, nil
+}
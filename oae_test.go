@@ -13,14 +13,14 @@ func TestOAEReadWrite(t *testing.T) {
 	const password = "asdf"
 	input := strings.Repeat("test input", 1024)
 	out := new(bytes.Buffer)
-	writer := newEncryptingWriter(out, password)
+	writer := newEncryptingWriter(t.Context(), out, password)
 	if _, err := io.WriteString(writer, input); err != nil {
 		t.Fatalf("Failed to write: %s", err)
 	}
 	if err := writer.close(); err != nil {
 		t.Fatalf("writer.Close() failed: %s", err)
 	}
-	got, err := io.ReadAll(newDecryptingReader(bytes.NewReader(out.Bytes()), password))
+	got, err := io.ReadAll(newDecryptingReader(t.Context(), bytes.NewReader(out.Bytes()), password))
 	if err != nil {
 		t.Fatalf("Failed to decrypt: %s", err)
 	}
@@ -28,3 +28,212 @@ func TestOAEReadWrite(t *testing.T) {
 		t.Errorf("Input failed to round-trip")
 	}
 }
+
+func TestOAE_Name(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	// Larger than a segment, so the name shifts data across segments.
+	input := strings.Repeat("test input", segmentSize/5)
+	out := new(bytes.Buffer)
+	writer := newEncryptingWriter(t.Context(), out, password)
+	writer.name = "file.txt"
+	if _, err := io.WriteString(writer, input); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := writer.close(); err != nil {
+		t.Fatalf("writer.Close() failed: %s", err)
+	}
+	reader := newDecryptingReader(t.Context(), bytes.NewReader(out.Bytes()), password)
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %s", err)
+	}
+	if string(got) != input {
+		t.Errorf("Input failed to round-trip")
+	}
+	if reader.name != writer.name {
+		t.Errorf("Decrypted file has name %q, want %q", reader.name, writer.name)
+	}
+}
+
+func TestOAE_TamperedHeader(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	out := new(bytes.Buffer)
+	writer := newEncryptingWriter(t.Context(), out, password)
+	if _, err := io.WriteString(writer, "test input"); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := writer.close(); err != nil {
+		t.Fatalf("writer.Close() failed: %s", err)
+	}
+	encrypted := out.Bytes()
+	// Add the archive flag to the header.
+	h, err := readHeader(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatalf("Failed to read header: %s", err)
+	}
+	h.archive = true
+	tampered := append(h.marshal(), encrypted[len(h.raw):]...)
+	if _, err := io.ReadAll(newDecryptingReader(t.Context(), bytes.NewReader(tampered), password)); err == nil {
+		t.Error("Decrypting file with tampered header succeeded, want error")
+	}
+}
+
+func TestSegmentReaderAt(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	input := make([]byte, 3*plaintextSegmentSize+100)
+	for i := range input {
+		input[i] = byte(i)
+	}
+	out := new(bytes.Buffer)
+	writer := newEncryptingWriter(t.Context(), out, password)
+	if _, err := writer.Write(input); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := writer.close(); err != nil {
+		t.Fatalf("writer.Close() failed: %s", err)
+	}
+	encrypted := bytes.NewReader(out.Bytes())
+	r, err := newSegmentReaderAt(encrypted, encrypted.Size(), newDecryptingReader(t.Context(), encrypted, password))
+	if err != nil {
+		t.Fatalf("newSegmentReaderAt failed: %s", err)
+	}
+	if got, want := r.Size(), int64(len(input)); got != want {
+		t.Errorf("Size() = %d, want %d", got, want)
+	}
+	for _, off := range []int64{0, plaintextSegmentSize - 10, 2*plaintextSegmentSize + 5, int64(len(input)) - 50} {
+		got := make([]byte, 50)
+		if _, err := r.ReadAt(got, off); err != nil {
+			t.Fatalf("ReadAt(%d) failed: %s", off, err)
+		}
+		if want := input[off : off+50]; !bytes.Equal(got, want) {
+			t.Errorf("ReadAt(%d) returned incorrect contents", off)
+		}
+	}
+
+	// A truncated file should fail to authenticate.
+	truncated := bytes.NewReader(out.Bytes()[:out.Len()-segmentSize])
+	r, err = newSegmentReaderAt(truncated, truncated.Size(), newDecryptingReader(t.Context(), truncated, password))
+	if err != nil {
+		t.Fatalf("newSegmentReaderAt failed: %s", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 1), r.Size()-1); err == nil {
+		t.Error("ReadAt succeeded for truncated file, want error")
+	}
+}
+
+func TestOAE_PasswordNormalization(t *testing.T) {
+	t.Parallel()
+
+	const (
+		composed   = "caf\u00e9"
+		decomposed = "cafe\u0301"
+	)
+	for _, tc := range []struct {
+		desc      string
+		legacy    bool // the file doesn't record a normalization form
+		encryptPW string
+		decryptPW string
+		wantErr   bool
+	}{{
+		desc:      "Decomposed",
+		encryptPW: decomposed,
+		decryptPW: composed,
+	}, {
+		desc:      "Composed",
+		encryptPW: composed,
+		decryptPW: decomposed,
+	}, {
+		desc:      "LegacyRaw",
+		legacy:    true,
+		encryptPW: decomposed,
+		decryptPW: decomposed,
+	}, {
+		desc:      "LegacyDecomposed",
+		legacy:    true,
+		encryptPW: decomposed,
+		decryptPW: composed,
+	}, {
+		desc:      "LegacyComposed",
+		legacy:    true,
+		encryptPW: composed,
+		decryptPW: decomposed,
+	}, {
+		desc:      "WrongPassword",
+		legacy:    true,
+		encryptPW: composed,
+		decryptPW: "cafe",
+		wantErr:   true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			key := passwordKey(tc.encryptPW)
+			if tc.legacy {
+				key = func(h *header) ([]byte, error) {
+					h.norm = normNone
+					return hashPassword(tc.encryptPW, h.salt), nil
+				}
+			}
+			out := new(bytes.Buffer)
+			writer := newKeyedEncryptingWriter(t.Context(), out, key)
+			if _, err := io.WriteString(writer, "test input"); err != nil {
+				t.Fatalf("Failed to write: %s", err)
+			}
+			if err := writer.close(); err != nil {
+				t.Fatalf("writer.Close() failed: %s", err)
+			}
+			got, err := io.ReadAll(newDecryptingReader(t.Context(), bytes.NewReader(out.Bytes()), tc.decryptPW))
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Decrypt returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if !tc.wantErr && string(got) != "test input" {
+				t.Errorf("Input failed to round-trip")
+			}
+		})
+	}
+}
+
+func TestOAE_Fallback(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	encrypt := func(key keyFunc) []byte {
+		out := new(bytes.Buffer)
+		writer := newKeyedEncryptingWriter(t.Context(), out, key)
+		if _, err := io.WriteString(writer, "test input"); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+		if err := writer.close(); err != nil {
+			t.Fatalf("writer.Close() failed: %s", err)
+		}
+		return out.Bytes()
+	}
+	// The key function's own key is wrong, but its fallback is right.
+	wrongKey := passwordKey("wrong")
+	key := func(h *header) ([]byte, error) {
+		h.fallback = passwordKey(password)
+		return wrongKey(h)
+	}
+	reader := newKeyedDecryptingReader(t.Context(), bytes.NewReader(encrypt(passwordKey(password))), key)
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decrypt with the fallback: %s", err)
+	}
+	if string(got) != "test input" {
+		t.Errorf("Input failed to round-trip")
+	}
+	if !reader.header.fallbackUsed {
+		t.Error("Header doesn't record that the fallback was used")
+	}
+	// Encrypting again uses the key function that worked.
+	reencrypted := encrypt(sameKeyAs(reader.header, key))
+	if _, err := io.ReadAll(newDecryptingReader(t.Context(), bytes.NewReader(reencrypted), password)); err != nil {
+		t.Errorf("Failed to decrypt the file encrypted again: %s", err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+// Output files are written to a temporary file in the same directory,
+// which is synced and then renamed into place, so that a crash never
+// leaves a partially written file under the real name. When the output
+// is a symlink, the file it points to is replaced instead. Devices,
+// pipes and names like /dev/stdout are written directly.
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+type outputFile struct {
+	*os.File
+
+	name      string // the final name of the file
+	force     bool
+	committed bool
+	// direct is set if the file is written in place, instead of
+	// through a temporary file.
+	direct bool
+}
+
+// createOutputFile creates a new output file. If force is set, an
+// existing file is replaced, otherwise it is an error for the file to
+// exist. The file must be committed, or it is discarded by abort.
+func createOutputFile(name string, force bool) (*outputFile, error) {
+	var mode fs.FileMode // the mode of the replaced file, if any
+	if force {
+		fi, err := os.Lstat(name)
+		if err == nil && fi.Mode()&fs.ModeSymlink != 0 && !openFileName(name) {
+			// Replace the file that the symlink points to, so that
+			// the symlink is kept.
+			if name, err = filepath.EvalSymlinks(name); err != nil {
+				return nil, err
+			}
+			fi, err = os.Lstat(name)
+		}
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, err
+		case fi.Mode().IsRegular() && !openFileName(name):
+			// Don't replace files that we wouldn't be allowed to
+			// write to.
+			f, err := os.OpenFile(name, os.O_WRONLY, 0)
+			if err != nil {
+				return nil, err
+			}
+			f.Close()
+			mode = fi.Mode().Perm()
+		default:
+			// Write to devices, pipes and names like /dev/stdout
+			// directly, instead of replacing them.
+			flag := os.O_WRONLY
+			if fi, err := os.Stat(name); err == nil && fi.Mode().IsRegular() {
+				// Add to the file, like writes to the descriptor
+				// that it names.
+				flag |= os.O_APPEND
+			}
+			f, err := os.OpenFile(name, flag, 0)
+			if err != nil {
+				return nil, err
+			}
+			return &outputFile{File: f, name: name, force: force, direct: true}, nil
+		}
+	} else if _, err := os.Lstat(name); err == nil {
+		return nil, fmt.Errorf("output file %q exists (use -f to overwrite)", name)
+	}
+	dir, base := filepath.Split(name)
+	for {
+		tmpName := filepath.Join(dir, "."+base+"."+rand.Text()[:8]+".tmp")
+		f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Keep the mode of the replaced file, so that a private file
+		// doesn't become readable by others. New files get 0644 reduced
+		// by the umask, like with os.Create.
+		if mode != 0 {
+			if err := f.Chmod(mode); err != nil {
+				f.Close()
+				os.Remove(tmpName)
+				return nil, err
+			}
+		}
+		return &outputFile{File: f, name: name, force: force}, nil
+	}
+}
+
+// Chmod changes the mode of the file, unless it is written directly.
+func (f *outputFile) Chmod(mode fs.FileMode) error {
+	if f.direct {
+		return nil
+	}
+	return f.File.Chmod(mode)
+}
+
+// linkUnsupported reports whether err means that the filesystem doesn't
+// support hard links, like FAT or many network filesystems.
+func linkUnsupported(err error) bool {
+	return errors.Is(err, errors.ErrUnsupported) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EXDEV)
+}
+
+// commit syncs the file and renames it to its final name.
+func (f *outputFile) commit() error {
+	if f.direct {
+		f.committed = true
+		return f.Close()
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if f.force {
+		if err := os.Rename(f.Name(), f.name); err != nil {
+			return err
+		}
+	} else {
+		// Link fails if the file was created in the meantime, unlike
+		// Rename which would replace it.
+		err := os.Link(f.Name(), f.name)
+		if err != nil && linkUnsupported(err) {
+			// Without hard links, check that the file doesn't exist
+			// just before renaming.
+			if _, err := os.Lstat(f.name); err == nil {
+				return fmt.Errorf("output file %q exists (use -f to overwrite)", f.name)
+			}
+			err = os.Rename(f.Name(), f.name)
+		}
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("output file %q exists (use -f to overwrite)", f.name)
+			}
+			return err
+		}
+		os.Remove(f.Name())
+	}
+	f.committed = true
+	return syncDir(filepath.Dir(f.name))
+}
+
+// abort removes the temporary file, unless the file was committed. A
+// file that is written directly is only closed.
+func (f *outputFile) abort() {
+	if f.committed {
+		return
+	}
+	f.Close()
+	if !f.direct {
+		os.Remove(f.Name())
+	}
+}
+
+// openFileName reports whether name stands for a file descriptor, like
+// /dev/stdout or /dev/fd/3. The file it points to is already open, so
+// replacing it would lose the writes of whoever else has it open.
+func openFileName(name string) bool {
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	switch name {
+	case "/dev/stdout", "/dev/stderr":
+		return true
+	}
+	if ok, _ := filepath.Match("/proc/*/fd/*", name); ok {
+		return true
+	}
+	return strings.HasPrefix(name, "/dev/fd/")
+}
+
+// syncDir syncs a directory, so that renames inside it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// writeFileAtomic is like os.WriteFile, but uses an outputFile.
+func writeFileAtomic(name string, data []byte) error {
+	f, err := createOutputFile(name, true)
+	if err != nil {
+		return err
+	}
+	defer f.abort()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+// checkNotInput returns an error if the output file name is the input
+// file in, since replacing it would destroy the input before it was
+// read.
+func checkNotInput(in *os.File, name string) error {
+	err := fmt.Errorf("output file %q is the input file", name)
+	inInfo, statErr := in.Stat()
+	if statErr != nil {
+		return statErr
+	}
+	if outInfo, statErr := os.Stat(name); statErr == nil && os.SameFile(inInfo, outInfo) {
+		return err
+	}
+	inAbs, err1 := filepath.Abs(in.Name())
+	outAbs, err2 := filepath.Abs(name)
+	if err1 == nil && err2 == nil && inAbs == outAbs {
+		return err
+	}
+	return nil
+}
+
+// checkRemovable returns an error if name is a symlink. Removing it
+// would only remove the link, and leave the file it points to behind.
+func checkRemovable(name string) error {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("refusing to remove %q: it is a symlink (use the file it points to)", name)
+	}
+	return nil
+}
+
+// removeFile removes a file and syncs its directory. If overwrite is set,
+// the contents are first overwritten with random data. This is only
+// best-effort, since copy-on-write filesystems and SSDs write the new
+// data somewhere else.
+func removeFile(name string, overwrite bool) error {
+	if overwrite {
+		f, err := os.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(f, rand.Reader, fi.Size()); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestOutputFile(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		exists  bool
+		force   bool
+		commit  bool
+		wantErr bool
+		want    string
+	}{{
+		desc:   "Commit",
+		commit: true,
+		want:   "new",
+	}, {
+		desc: "Abort",
+	}, {
+		desc:    "Exists",
+		exists:  true,
+		wantErr: true,
+		want:    "old",
+	}, {
+		desc:   "ForceCommit",
+		exists: true,
+		force:  true,
+		commit: true,
+		want:   "new",
+	}, {
+		desc:   "ForceAbort",
+		exists: true,
+		force:  true,
+		want:   "old",
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			fileName := filepath.Join(dir, "file")
+			if tc.exists {
+				mustWriteFile(t, fileName, []byte("old"))
+			}
+			f, err := createOutputFile(fileName, tc.force)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("createOutputFile(force=%t) returned error %v, want error? %t", tc.force, err, tc.wantErr)
+			}
+			if err == nil {
+				if _, err := f.WriteString("new"); err != nil {
+					t.Fatalf("Failed to write: %s", err)
+				}
+				if tc.exists {
+					if got := mustReadFile(t, fileName); string(got) != "old" {
+						t.Errorf("Output file was modified before commit")
+					}
+				} else if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+					t.Errorf("Output file was created before commit")
+				}
+				if tc.commit {
+					if err := f.commit(); err != nil {
+						t.Fatalf("commit failed: %s", err)
+					}
+				}
+				f.abort()
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("Failed to read directory: %s", err)
+			}
+			if tc.want == "" {
+				if len(entries) != 0 {
+					t.Errorf("Directory contains %d files after abort, want 0", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Errorf("Directory contains %d files, want 1", len(entries))
+			}
+			if got := mustReadFile(t, fileName); string(got) != tc.want {
+				t.Errorf("Output file has content %q, want %q", got, tc.want)
+			}
+			if runtime.GOOS == "windows" {
+				return
+			}
+			// A replaced file keeps its mode, and a new file gets the
+			// mode that the umask allows.
+			fi, err := os.Stat(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want os.FileMode = 0600
+			if !tc.exists {
+				ref := filepath.Join(t.TempDir(), "ref")
+				if err := os.WriteFile(ref, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				refInfo, err := os.Stat(ref)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want = refInfo.Mode().Perm()
+			}
+			if mode := fi.Mode().Perm(); mode != want {
+				t.Errorf("Output file has mode %v, want %v", mode, want)
+			}
+		})
+	}
+}
+
+func TestOutputFile_NotRegular(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("no /dev/null")
+	}
+	f, err := createOutputFile("/dev/null", true)
+	if err != nil {
+		t.Fatalf("createOutputFile failed: %s", err)
+	}
+	defer f.abort()
+	if _, err := f.WriteString("new"); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := f.commit(); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	fi, err := os.Stat("/dev/null")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().IsRegular() {
+		t.Error("/dev/null was replaced by a regular file")
+	}
+}
+
+func TestOutputFile_Symlink(t *testing.T) {
+	t.Parallel()
+
+	for _, commit := range []bool{false, true} {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "target")
+		link := filepath.Join(dir, "link")
+		mustWriteFile(t, target, []byte("old"))
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("Failed to create symlink: %s", err)
+		}
+		f, err := createOutputFile(link, true)
+		if err != nil {
+			t.Fatalf("createOutputFile failed: %s", err)
+		}
+		if _, err := f.WriteString("new"); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+		if got := mustReadFile(t, target); string(got) != "old" {
+			t.Errorf("Symlink target was modified before commit")
+		}
+		want := "old"
+		if commit {
+			if err := f.commit(); err != nil {
+				t.Fatalf("commit failed: %s", err)
+			}
+			want = "new"
+		}
+		f.abort()
+		if got := mustReadFile(t, target); string(got) != want {
+			t.Errorf("Symlink target has content %q after commit=%t, want %q", got, commit, want)
+		}
+		if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Symlink was replaced")
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+// A paper key is a keyfile or a passphrase written as words of
+// wordlist.Words, for printing and storing offline. The secret is
+// prefixed by its kind, its length and a checksum of it, split into 13
+// bit words, and laid out in numbered lines that each end with a
+// checksum word. The checksum covers the line number, so that swapped
+// lines are caught too. When importing, a damaged word in a line is
+// corrected by trying the similar words of the word list until the
+// checksum matches. A line checksum is only 13 bits, so a wrong
+// correction can match it too; the checksum of the secret catches
+// those.
+//
+// The page also has a QR code of the lines, if they fit, so that a
+// phone can scan the page and the text can be imported directly.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/subcommands"
+	"roseh.moe/pkg/wordlist"
+	"rsc.io/qr"
+)
+
+// Kinds of secrets on a paper key.
+const (
+	paperKeyfile    = 1
+	paperPassphrase = 2
+)
+
+const (
+	paperWordsPerLine = 6
+	paperChecksumSize = 4
+	paperHeaderSize   = 3 + paperChecksumSize // the kind, the length and the checksum
+)
+
+// paperChecksum returns the checksum of the secret in the header.
+func paperChecksum(secret []byte) []byte {
+	sum := sha256.Sum256(secret)
+	return sum[:paperChecksumSize]
+}
+
+// paperLine matches the numbered lines of a paper key. The number is
+// not parsed, since OCR may have damaged it.
+var paperLine = regexp.MustCompile(`^\s*\S{1,4}:\s+(.*)$`)
+
+// lineChecksum returns the index of the checksum word of line number
+// line, whose other words have the given indexes.
+func lineChecksum(line int, indexes []int) int {
+	return checksumIndex(append([]int{line}, indexes...), len(wordlist.Words))
+}
+
+// paperLines encodes the secret as the numbered lines of a paper key.
+func paperLines(kind byte, secret []byte) []string {
+	b := []byte{kind}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(secret)))
+	b = append(b, paperChecksum(secret)...)
+	b = append(b, secret...)
+	indexes := bytesToWords(b)
+	var lines []string
+	for line := 1; len(indexes) > 0; line++ {
+		n := min(len(indexes), paperWordsPerLine)
+		var words []string
+		for _, i := range indexes[:n] {
+			words = append(words, wordlist.Words[i])
+		}
+		words = append(words, wordlist.Words[lineChecksum(line, indexes[:n])])
+		lines = append(lines, fmt.Sprintf("%2d: %s", line, strings.Join(words, " ")))
+		indexes = indexes[n:]
+	}
+	return lines
+}
+
+// qrText draws the QR code with block characters, two rows of modules
+// per line of text, with a quiet zone of 4 modules around it. Dark
+// modules are drawn dark, as they should be when printed.
+func qrText(code *qr.Code) string {
+	const quiet = 4
+	var b strings.Builder
+	for y := -quiet; y < code.Size+quiet; y += 2 {
+		for x := -quiet; x < code.Size+quiet; x++ {
+			switch top, bottom := code.Black(x, y), code.Black(x, y+1); {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// writePaperKey writes the printable page for the secret to w.
+func writePaperKey(w io.Writer, kind byte, secret []byte) error {
+	lines := paperLines(kind, secret)
+	what := "keyfile"
+	if kind == paperPassphrase {
+		what = "passphrase"
+	}
+	// Large keyfiles don't fit in a QR code, and only have the lines.
+	restore := `Restore it with sym paperkey -import, typing the lines below or
+scanning the QR code. The last word of each line is a checksum.`
+	qrCode := ""
+	if code, err := qr.Encode(strings.Join(lines, "\n"), qr.M); err == nil {
+		qrCode = "\n" + qrText(code)
+	} else {
+		fmt.Fprintf(os.Stderr, "sym: warning: the %s is too large for a QR code, the page only has the lines of words\n", what)
+		restore = `Restore it with sym paperkey -import, typing the lines below. The
+last word of each line is a checksum.`
+	}
+	_, err := fmt.Fprintf(w, "SYM PAPER KEY (%s)\n%s\n\n%s\n%s", what, restore, strings.Join(lines, "\n"), qrCode)
+	return err
+}
+
+// readPaperKey reads a page written by writePaperKey and returns the
+// kind of secret and the secret. Lines that are not numbered lines of
+// words are skipped. Damaged words are corrected, with a warning, if
+// the line checksum allows it.
+func readPaperKey(r io.Reader) (kind byte, secret []byte, err error) {
+	var indexes []int
+	line := 0
+	corrected := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := paperLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		line++
+		lineIndexes, fixed, err := readPaperLine(line, strings.Fields(strings.ToLower(m[1])))
+		if err != nil {
+			return 0, nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		corrected = corrected || fixed
+		indexes = append(indexes, lineIndexes...)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	if len(indexes)*wordBits < paperHeaderSize*8 {
+		return 0, nil, errors.New("no paper key found")
+	}
+	header := wordsToBytes(indexes, paperHeaderSize)
+	kind, n := header[0], int(binary.BigEndian.Uint16(header[1:]))
+	if kind != paperKeyfile && kind != paperPassphrase {
+		return 0, nil, fmt.Errorf("unknown kind of paper key %d", kind)
+	}
+	if want := len(bytesToWords(make([]byte, paperHeaderSize+n))); len(indexes) != want {
+		return 0, nil, fmt.Errorf("paper key has %d words, want %d (is a line missing?)", len(indexes), want)
+	}
+	secret = wordsToBytes(indexes, paperHeaderSize+n)[paperHeaderSize:]
+	if !bytes.Equal(paperChecksum(secret), header[3:]) {
+		if corrected {
+			return 0, nil, errors.New("checksum of the paper key does not match, a corrected word may be wrong (type the lines with the warnings again)")
+		}
+		return 0, nil, errors.New("checksum of the paper key does not match")
+	}
+	return kind, secret, nil
+}
+
+// readPaperLine checks the checksum of the words of line number line,
+// and returns the indexes of the words without the checksum word, and
+// whether a damaged word was corrected.
+func readPaperLine(line int, words []string) (indexes []int, corrected bool, err error) {
+	if len(words) < 2 || len(words) > paperWordsPerLine+1 {
+		return nil, false, fmt.Errorf("line has %d words, want 2 to %d", len(words), paperWordsPerLine+1)
+	}
+	indexes = make([]int, len(words))
+	unknown := -1
+	for i, word := range words {
+		index, ok := wordIndexes()[word]
+		if !ok && unknown >= 0 {
+			return nil, false, fmt.Errorf("unknown words %q and %q", words[unknown], word)
+		}
+		if !ok {
+			unknown = i
+		}
+		indexes[i] = index
+	}
+	last := len(words) - 1
+	valid := func(indexes []int) bool {
+		return lineChecksum(line, indexes[:last]) == indexes[last]
+	}
+	if unknown < 0 && valid(indexes) {
+		return indexes[:last], false, nil
+	}
+	i, index, ok := fixTypo(words, indexes, unknown, valid)
+	if !ok {
+		return nil, false, errors.New("checksum does not match, and the line could not be corrected")
+	}
+	fmt.Fprintf(os.Stderr, "sym: warning: line %d: read %q as %q\n", line, words[i], wordlist.Words[index])
+	indexes[i] = index
+	return indexes[:last], true, nil
+}
+
+type paperkeyCmd struct {
+	importKey bool
+	output    string
+	force     bool
+
+	passwordIn func(prompt string) (string, error)
+	stdin      io.Reader
+	stdout     io.Writer
+}
+
+func (*paperkeyCmd) Name() string     { return "paperkey" }
+func (*paperkeyCmd) Synopsis() string { return "print a key or passphrase for offline backup" }
+func (*paperkeyCmd) Usage() string {
+	return `usage: sym paperkey [OPTION]... [KEYFILE]
+       sym paperkey -import [OPTION]... [PAGE]
+Write a keyfile, or a passphrase read from the terminal, as a page of
+words to print and store offline. Each line of words ends with a
+checksum word, and the page has a QR code of the lines, drawn with
+block characters. Keyfiles larger than about 500 bytes don't fit in a
+QR code, and only have the lines. Example:
+  sym paperkey -o backup.txt /media/usb/backup.key
+
+With -import, the keyfile or passphrase is read back from the page, or
+from the text scanned from the QR code. The page can be typed or
+OCR-scanned: lines other than the numbered lines of words are skipped,
+and a damaged word in a line is corrected using the line checksum. A
+checksum of the whole keyfile or passphrase catches wrong corrections.
+Keyfiles are written to the file given with -o, and passphrases are
+printed. Example:
+  sym paperkey -import -o backup.key scanned.txt
+
+`
+}
+
+func (c *paperkeyCmd) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.importKey, "import", false, "read a keyfile or passphrase back from a paper key")
+	fs.StringVar(&c.output, "o", "", "write the output to `file`")
+	fs.BoolVar(&c.force, "f", false, "overwrite the output file if it already exists")
+}
+
+// export writes the paper key for the keyfile, or for a passphrase read
+// from the terminal if fileName is empty.
+func (c *paperkeyCmd) export(fileName string) error {
+	kind, secret := byte(paperKeyfile), []byte(nil)
+	if fileName != "" {
+		var err error
+		if secret, err = os.ReadFile(fileName); err != nil {
+			return err
+		}
+		if len(secret) == 0 {
+			return fmt.Errorf("keyfile %q is empty", fileName)
+		}
+	} else {
+		password, err := c.passwordIn("Enter passphrase: ")
+		if err != nil {
+			return err
+		}
+		if password == "" {
+			return usageErr("passphrase cannot be empty")
+		}
+		pwConfirm, err := c.passwordIn("Repeat passphrase: ")
+		if err != nil {
+			return err
+		}
+		if pwConfirm != password {
+			return usageErr("passphrases do not match")
+		}
+		kind, secret = paperPassphrase, []byte(password)
+	}
+	if len(secret) > 0xffff {
+		return fmt.Errorf("keyfile %q is too large for a paper key", fileName)
+	}
+	return c.write(func(w io.Writer) error {
+		return writePaperKey(w, kind, secret)
+	})
+}
+
+// importFrom reads a paper key from fileName, or stdin if it is empty.
+func (c *paperkeyCmd) importFrom(fileName string) error {
+	r := c.stdin
+	if fileName != "" {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	kind, secret, err := readPaperKey(r)
+	if err != nil {
+		return err
+	}
+	if kind == paperKeyfile && c.output == "" {
+		return usageErr("the paper key holds a keyfile, use -o to choose where to write it")
+	}
+	if kind == paperPassphrase {
+		secret = append(secret, '\n')
+	}
+	return c.write(func(w io.Writer) error {
+		_, err := w.Write(secret)
+		return err
+	})
+}
+
+// write calls f with the output file given with -o, or stdout.
+func (c *paperkeyCmd) write(f func(w io.Writer) error) error {
+	if c.output == "" {
+		return f(c.stdout)
+	}
+	fOut, err := createOutputFile(c.output, c.force)
+	if err != nil {
+		return err
+	}
+	defer fOut.abort()
+	// The output holds the secret, only its owner should read it.
+	if err := fOut.Chmod(0600); err != nil {
+		return err
+	}
+	if err := f(fOut); err != nil {
+		return err
+	}
+	return fOut.commit()
+}
+
+func (c *paperkeyCmd) run(args ...string) error {
+	if len(args) > 1 {
+		return usageErr("paperkey takes at most one file")
+	}
+	fileName := ""
+	if len(args) == 1 {
+		fileName = args[0]
+	}
+	if c.importKey {
+		return c.importFrom(fileName)
+	}
+	return c.export(fileName)
+}
+
+func (c *paperkeyCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return exitStatus(ctx, c.run(f.Args()...))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPaperKey(t *testing.T) {
+	t.Parallel()
+
+	// A keyfile with known contents rather than a random one, so that
+	// the damaged lines are the same on every run.
+	keyfile := make([]byte, keyfileSize)
+	for i := range keyfile {
+		keyfile[i] = byte(i * 37)
+	}
+	var page bytes.Buffer
+	if err := writePaperKey(&page, paperKeyfile, keyfile); err != nil {
+		t.Fatalf("writePaperKey failed: %s", err)
+	}
+	lines := paperLines(paperKeyfile, keyfile)
+	// A line of another keyfile has a valid line checksum, like a
+	// wrong correction could.
+	other := bytes.Clone(keyfile)
+	other[len(other)-1] ^= 1
+	otherLines := paperLines(paperKeyfile, other)
+	last := len(lines) - 1
+	// damage applies f to the words of line i of the page.
+	damage := func(i int, f func(words []string)) string {
+		prefix, rest, _ := strings.Cut(lines[i], ": ")
+		words := strings.Fields(rest)
+		f(words)
+		return strings.Replace(page.String(), lines[i], prefix+": "+strings.Join(words, " "), 1)
+	}
+
+	for _, tc := range []struct {
+		desc    string
+		page    string
+		wantErr bool
+	}{{
+		desc: "Page",
+		page: page.String(),
+	}, {
+		desc: "Lines",
+		page: strings.Join(lines, "\n"),
+	}, {
+		desc: "Uppercase",
+		page: strings.ToUpper(strings.Join(lines, "\n")),
+	}, {
+		desc: "DamagedNumber",
+		page: strings.Replace(page.String(), " 1: ", " l: ", 1),
+	}, {
+		desc: "DamagedWord",
+		page: damage(1, func(words []string) { words[2] = typo(words[2]) }),
+	}, {
+		desc: "DamagedChecksum",
+		page: damage(2, func(words []string) { words[len(words)-1] = typo(words[len(words)-1]) }),
+	}, {
+		desc: "DamagedWords",
+		page: damage(0, func(words []string) {
+			words[0] = typo(words[0])
+			words[1] = typo(words[1])
+		}),
+		wantErr: true,
+	}, {
+		desc:    "OtherLine",
+		page:    strings.Replace(page.String(), lines[last], otherLines[last], 1),
+		wantErr: true,
+	}, {
+		desc:    "SwappedLines",
+		page:    strings.Join(append([]string{lines[1], lines[0]}, lines[2:]...), "\n"),
+		wantErr: true,
+	}, {
+		desc:    "MissingLine",
+		page:    strings.Join(lines[:len(lines)-1], "\n"),
+		wantErr: true,
+	}, {
+		desc:    "Empty",
+		wantErr: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			kind, got, err := readPaperKey(strings.NewReader(tc.page))
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("readPaperKey returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if kind != paperKeyfile || !bytes.Equal(got, keyfile) {
+				t.Errorf("readPaperKey returned %d, %x, want %d, %x", kind, got, paperKeyfile, keyfile)
+			}
+		})
+	}
+}
+
+func TestPaperkeyCmd(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc        string
+		keyfile     bool
+		keyfileSize int
+		password    string
+	}{
+		{desc: "Keyfile", keyfile: true},
+		{desc: "LargeKeyfile", keyfile: true, keyfileSize: 1000},
+		{desc: "Passphrase", password: "correct horse battery staple"},
+		{desc: "Unicode", password: "café \U0001f511"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			keyfile := filepath.Join(dir, "key")
+			var args []string
+			if tc.keyfile {
+				if tc.keyfileSize > 0 {
+					mustWriteFile(t, keyfile, bytes.Repeat([]byte{0xa5}, tc.keyfileSize))
+				} else if err := (&keygenCmd{keyfile: keyfile}).run(); err != nil {
+					t.Fatalf("keygen failed: %s", err)
+				}
+				args = append(args, keyfile)
+			}
+			page := filepath.Join(dir, "page.txt")
+			export := &paperkeyCmd{
+				output: page,
+				passwordIn: func(string) (string, error) {
+					return tc.password, nil
+				},
+			}
+			if err := export.run(args...); err != nil {
+				t.Fatalf("paperkey failed: %s", err)
+			}
+
+			out := filepath.Join(dir, "out")
+			var stdout bytes.Buffer
+			imp := &paperkeyCmd{importKey: true, stdout: &stdout}
+			if tc.keyfile {
+				imp.output = out
+			}
+			if err := imp.run(page); err != nil {
+				t.Fatalf("paperkey -import failed: %s", err)
+			}
+			if tc.keyfile {
+				if got, want := mustReadFile(t, out), mustReadFile(t, keyfile); !bytes.Equal(got, want) {
+					t.Errorf("Imported keyfile %x, want %x", got, want)
+				}
+				return
+			}
+			if got, want := stdout.String(), tc.password+"\n"; got != want {
+				t.Errorf("Imported passphrase %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestPaperkeyCmd_KeyfileNeedsOutput(t *testing.T) {
+	t.Parallel()
+
+	var page bytes.Buffer
+	if err := writePaperKey(&page, paperKeyfile, []byte("key")); err != nil {
+		t.Fatalf("writePaperKey failed: %s", err)
+	}
+	var stdout bytes.Buffer
+	c := &paperkeyCmd{importKey: true, stdin: &page, stdout: &stdout}
+	if err := c.run(); err == nil {
+		t.Error("paperkey -import wrote a keyfile to stdout")
+	}
+	if stdout.Len() > 0 {
+		t.Errorf("paperkey -import wrote %q to stdout", stdout.String())
+	}
+}
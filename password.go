@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"golang.org/x/term"
+)
+
+// passwordSource reads the password from a file, a file descriptor, an
+// environment variable or a helper command, so that it doesn't show up
+// in the process list or shell history like a password given with -p.
+type passwordSource struct {
+	file  string
+	fd    int
+	hasFD bool
+	env   string
+	cmd   string
+
+	// helper is the password helper that was run, if any.
+	helper *passwordHelper
+}
+
+const passwordSourceUsage = `Instead of -p, the password can be read from the first line of a file
+with -password-file, from a file descriptor with -password-fd, from
+an environment variable with -password-env, or from a helper command
+with -password-cmd. Only one way of giving the password can be used at
+a time.
+
+-password-cmd runs a shell command, or the program sym-password-NAME
+if only NAME is given. The command prints the password as the first
+line of its output. It is given key=value lines describing the request
+on stdin: action (get, or erase when decryption failed with the
+password), operation (encrypt or decrypt), file and key-id (the salt
+from the header of each encrypted file). Example:
+  sym dec -password-cmd 'pass show backups/sym' backup.tar.enc
+`
+
+func (p *passwordSource) setFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.file, "password-file", "", "read the password from the first line of `file`")
+	fs.Func("password-fd", "read the password from the first line of file descriptor `N`, which is closed afterwards", func(s string) error {
+		fd, err := strconv.Atoi(s)
+		if err != nil || fd < 0 {
+			return fmt.Errorf("invalid file descriptor %q", s)
+		}
+		p.fd, p.hasFD = fd, true
+		return nil
+	})
+	fs.StringVar(&p.env, "password-env", "", "read the password from the environment variable `VAR`")
+	fs.StringVar(&p.cmd, "password-cmd", "", "get the password from the helper `command`")
+}
+
+// count returns the number of sources that were given.
+func (p *passwordSource) count() int {
+	n := 0
+	for _, set := range []bool{p.file != "", p.hasFD, p.env != "", p.cmd != ""} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// read reads the password from the source that was given. ok is false
+// if no source was given. operation and files are passed to the
+// password helper.
+func (p *passwordSource) read(operation string, files []string) (password string, ok bool, err error) {
+	var name string
+	switch {
+	case p.file != "":
+		name = fmt.Sprintf("password file %q", p.file)
+		f, err := os.Open(p.file)
+		if err != nil {
+			return "", false, err
+		}
+		defer f.Close()
+		password, err = readLine(f)
+		if err != nil {
+			return "", false, fmt.Errorf("read %s: %s", name, err)
+		}
+	case p.hasFD:
+		name = fmt.Sprintf("file descriptor %d", p.fd)
+		f := os.NewFile(uintptr(p.fd), name)
+		defer f.Close()
+		password, err = readLine(f)
+		if err != nil {
+			return "", false, fmt.Errorf("read %s: %s", name, err)
+		}
+	case p.env != "":
+		name = fmt.Sprintf("environment variable %s", p.env)
+		var set bool
+		if password, set = os.LookupEnv(p.env); !set {
+			return "", false, fmt.Errorf("%s is not set", name)
+		}
+	case p.cmd != "":
+		p.helper = &passwordHelper{cmd: p.cmd, operation: operation, files: files}
+		if password, err = p.helper.get(); err != nil {
+			return "", false, err
+		}
+	default:
+		return "", false, nil
+	}
+	if password == "" {
+		return "", false, fmt.Errorf("password from %s is empty", name)
+	}
+	return password, true, nil
+}
+
+// reportFailure tells the password helper, if one was used, that
+// decryption failed with the password it returned.
+func (p *passwordSource) reportFailure(failure error) {
+	if p.helper == nil {
+		return
+	}
+	if err := p.helper.erase(failure); err != nil {
+		fmt.Fprintf(os.Stderr, "sym: %s\n", err)
+	}
+}
+
+// readLine reads the first line of r, without the line ending. It reads
+// one byte at a time so that nothing after the line is consumed.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	var b [1]byte
+	for {
+		n, err := r.Read(b[:])
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return string(line), nil
+}
+
+// warnPasswordFlag warns about passwords given with -p when sym is used
+// interactively.
+func warnPasswordFlag() {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "sym: warning: -p exposes the password to other users and in shell history; consider -password-file, -password-fd, -password-env or -password-cmd")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordSource(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	mustWriteFile(t, passwordFile, []byte("file password\r\nsecond line\n"))
+	emptyFile := filepath.Join(t.TempDir(), "empty")
+	mustWriteFile(t, emptyFile, nil)
+	t.Setenv("SYM_TEST_PASSWORD", "env password")
+	t.Setenv("SYM_TEST_EMPTY", "")
+
+	for _, tc := range []struct {
+		desc    string
+		source  passwordSource
+		want    string
+		wantOK  bool
+		wantErr bool
+	}{{
+		desc: "None",
+	}, {
+		desc:   "File",
+		source: passwordSource{file: passwordFile},
+		want:   "file password",
+		wantOK: true,
+	}, {
+		desc:   "Env",
+		source: passwordSource{env: "SYM_TEST_PASSWORD"},
+		want:   "env password",
+		wantOK: true,
+	}, {
+		desc:    "FileNotFound",
+		source:  passwordSource{file: filepath.Join(t.TempDir(), "nonexistent")},
+		wantErr: true,
+	}, {
+		desc:    "EmptyFile",
+		source:  passwordSource{file: emptyFile},
+		wantErr: true,
+	}, {
+		desc:    "EnvNotSet",
+		source:  passwordSource{env: "SYM_TEST_NOT_SET"},
+		wantErr: true,
+	}, {
+		desc:    "EmptyEnv",
+		source:  passwordSource{env: "SYM_TEST_EMPTY"},
+		wantErr: true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, ok, err := tc.source.read("decrypt", nil)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("read() returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("read() = %q, %t, want %q, %t", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestEncCmd_Run_PasswordFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	mustWriteFile(t, passwordFile, []byte("asdf\n"))
+	fileName := filepath.Join(dir, "file")
+	mustWriteFile(t, fileName, []byte("test file content"))
+	if err := (&encCmd{pwSource: passwordSource{file: passwordFile}, allowWeak: true}).run(t.Context(), fileName); err != nil {
+		t.Fatalf("enc -password-file failed: %s", err)
+	}
+	if err := (&encCmd{password: "asdf", pwSource: passwordSource{file: passwordFile}}).run(t.Context(), fileName); err == nil {
+		t.Error("enc with -p and -password-file succeeded, want error")
+	}
+	mustRemove(t, fileName)
+	if err := (&decCmd{keys: keyFlags{password: "asdf"}}).run(t.Context(), fileName+".enc"); err != nil {
+		t.Fatalf("Failed to decrypt with the password from the file: %s", err)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
-	"os"
+	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/term"
+	"golang.org/x/text/unicode/norm"
 )
 
 var argon2Memory = 2 * 1024 * 1024
@@ -13,10 +18,63 @@ func hashPassword(password string, salt []byte) []byte {
 	return argon2.IDKey([]byte(password), salt, 1, uint32(argon2Memory), 4, 32)
 }
 
-func termReadPassword() (string, error) {
-	pw, err := term.ReadPassword(int(os.Stdin.Fd()))
+// normalizePassword returns password in the given normalization form,
+// so that the same password typed or pasted in different ways gives the
+// same key.
+func normalizePassword(password string, form byte) string {
+	switch form {
+	case normNFC:
+		return norm.NFC.String(password)
+	case normNFD:
+		return norm.NFD.String(password)
+	}
+	return password
+}
+
+// deriveKey derives a subkey from a secret key using HKDF. Each use of
+// a key should have its own info string.
+func deriveKey(secret, salt []byte, info string) []byte {
+	key, err := hkdf.Key(sha256.New, secret, salt, info, 32)
+	if err != nil {
+		panic(err) // impossible, the key is short
+	}
+	return key
+}
+
+// termReadPassword prints prompt and reads a password from the
+// terminal. The terminal is opened directly, so that stdin and stdout
+// can still be used for data. If ctx is canceled while waiting, the
+// terminal state is restored and the context's error is returned.
+func termReadPassword(ctx context.Context, prompt string) (string, error) {
+	tty, out, err := openTTY()
+	if err != nil {
+		return "", errors.New("cannot prompt for a password without a terminal (use -p or one of the -password-* flags)")
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	state, err := term.GetState(fd)
 	if err != nil {
 		return "", err
 	}
-	return string(pw), nil
+	fmt.Fprint(out, prompt)
+	defer fmt.Fprintln(out)
+	type result struct {
+		pw  []byte
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		pw, err := term.ReadPassword(fd)
+		ch <- result{pw, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return "", r.err
+		}
+		return string(r.pw), nil
+	case <-ctx.Done():
+		term.Restore(fd, state)
+		return "", ctx.Err()
+	}
 }
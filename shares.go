@@ -0,0 +1,257 @@
+package main
+
+// With shares, the key is derived from a random secret that is split
+// with Shamir's secret sharing, so that any k of n shares rebuild it
+// but fewer reveal nothing about it. Each byte of the secret is the
+// constant term of a random polynomial of degree k-1 over GF(256), and
+// share i holds the values of the polynomials at x = i.
+//
+// A share is encoded as the threshold, the x coordinate, a 2 byte ID of
+// the set of shares and the values, and written as words of
+// wordlist.Words, 13 bits per word, followed by a checksum word like
+// generated passphrases. The ID is random, so that shares of different
+// secrets are caught when combining them without the shares revealing
+// anything about the secret. A secret rebuilt from corrupted shares is
+// caught when decrypting, since the file doesn't authenticate with the
+// key derived from it.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"roseh.moe/pkg/wordlist"
+)
+
+const (
+	shareSecretSize = 32
+	shareIDSize     = 2
+	shareHeaderSize = 2 + shareIDSize
+	shareSize       = shareHeaderSize + shareSecretSize
+
+	sharesInfo = "sym shares"
+)
+
+// GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1, using log
+// and exp tables for the generator 3.
+var gfExp, gfLog = func() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := range 255 {
+		exp[i] = x
+		exp[i+255] = x
+		log[x] = byte(i)
+		// Multiply by 3: x*2 + x, reducing modulo the polynomial.
+		x2 := x << 1
+		if x&0x80 != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if b == 0 {
+		panic("division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+type share struct {
+	threshold byte
+	x         byte
+	id        []byte
+	y         []byte
+}
+
+// splitSecret splits secret into n shares, any k of which rebuild it.
+func splitSecret(secret []byte, k, n int) []*share {
+	id := make([]byte, shareIDSize)
+	rand.Read(id)
+	shares := make([]*share, n)
+	for i := range shares {
+		shares[i] = &share{threshold: byte(k), x: byte(i + 1), id: id, y: make([]byte, len(secret))}
+	}
+	coeffs := make([]byte, k)
+	for b, s := range secret {
+		coeffs[0] = s
+		rand.Read(coeffs[1:])
+		for _, sh := range shares {
+			// Horner's method.
+			var y byte
+			for j := k - 1; j >= 0; j-- {
+				y = gfMul(y, sh.x) ^ coeffs[j]
+			}
+			sh.y[b] = y
+		}
+	}
+	return shares
+}
+
+// combineShares rebuilds the secret from at least threshold shares.
+func combineShares(shares []*share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+	first := shares[0]
+	if len(shares) < int(first.threshold) {
+		return nil, fmt.Errorf("%d shares are needed, but only %d were given", first.threshold, len(shares))
+	}
+	shares = shares[:first.threshold]
+	for i, sh := range shares {
+		if sh.threshold != first.threshold || !bytes.Equal(sh.id, first.id) || len(sh.y) != len(first.y) {
+			return nil, errors.New("shares are from different secrets")
+		}
+		for _, other := range shares[:i] {
+			if sh.x == other.x {
+				return nil, fmt.Errorf("share %d was given twice", sh.x)
+			}
+		}
+	}
+	// Lagrange interpolation at x = 0. In GF(256), subtraction is XOR.
+	secret := make([]byte, len(first.y))
+	for i, sh := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(other.x, other.x^sh.x))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(sh.y[b], basis)
+		}
+	}
+	return secret, nil
+}
+
+// encode returns the share as words.
+func (sh *share) encode() string {
+	b := append([]byte{sh.threshold, sh.x}, sh.id...)
+	b = append(b, sh.y...)
+	indexes := bytesToWords(b)
+	words := make([]string, 0, len(indexes)+1)
+	for _, i := range indexes {
+		words = append(words, wordlist.Words[i])
+	}
+	words = append(words, wordlist.Words[checksumIndex(indexes, len(wordlist.Words))])
+	return strings.Join(words, " ")
+}
+
+// decodeShare parses a share written by encode.
+func decodeShare(s string) (*share, error) {
+	words := strings.Fields(s)
+	nWords := (shareSize*8 + wordBits - 1) / wordBits
+	if len(words) != nWords+1 {
+		return nil, fmt.Errorf("share has %d words, want %d", len(words), nWords+1)
+	}
+	indexes := make([]int, len(words))
+	unknown := -1
+	for i, word := range words {
+		index, ok := wordIndexes()[word]
+		if !ok && unknown >= 0 {
+			return nil, fmt.Errorf("unknown words %q and %q in share", words[unknown], word)
+		}
+		if !ok {
+			unknown = i
+		}
+		indexes[i] = index
+	}
+	valid := func(indexes []int) bool {
+		return checksumIndex(indexes[:nWords], len(wordlist.Words)) == indexes[nWords]
+	}
+	if unknown >= 0 || !valid(indexes) {
+		if i, index, ok := fixTypo(words, indexes, unknown, valid); ok {
+			return nil, fmt.Errorf("share checksum does not match, did you mean %q instead of %q (word %d)?", wordlist.Words[index], words[i], i+1)
+		}
+		if unknown >= 0 {
+			return nil, fmt.Errorf("unknown word %q in share", words[unknown])
+		}
+		return nil, errors.New("share checksum does not match")
+	}
+	b := wordsToBytes(indexes, shareSize)
+	sh := &share{
+		threshold: b[0],
+		x:         b[1],
+		id:        b[2:shareHeaderSize],
+		y:         b[shareHeaderSize:],
+	}
+	if sh.threshold == 0 || sh.x == 0 {
+		return nil, errors.New("malformed share")
+	}
+	return sh, nil
+}
+
+// parseShares parses the k/n argument of -shares.
+func parseShares(s string) (k, n int, err error) {
+	ks, ns, ok := strings.Cut(s, "/")
+	if ok {
+		k, err = strconv.Atoi(ks)
+	}
+	if ok && err == nil {
+		n, err = strconv.Atoi(ns)
+	}
+	if !ok || err != nil || k < 1 || k > n || n > 255 {
+		return 0, 0, usageErr("-shares must be k/n, with 1 <= k <= n <= 255")
+	}
+	return k, n, nil
+}
+
+// readShares decodes the shares given as words and in files.
+func readShares(words, fileNames []string) ([]*share, error) {
+	var shares []*share
+	for i, s := range words {
+		sh, err := decodeShare(s)
+		if err != nil {
+			return nil, fmt.Errorf("share %d: %s", i+1, err)
+		}
+		shares = append(shares, sh)
+	}
+	for _, fileName := range fileNames {
+		b, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		sh, err := decodeShare(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("share %q: %s", fileName, err)
+		}
+		shares = append(shares, sh)
+	}
+	return shares, nil
+}
+
+type sharesKey struct {
+	secret []byte
+}
+
+// encryptKey is the keyFunc for encrypting. It records in the header
+// that the key is derived from shares.
+func (k *sharesKey) encryptKey(h *header) ([]byte, error) {
+	h.shares = true
+	return deriveKey(k.secret, h.salt, sharesInfo), nil
+}
+
+// decryptKey is the keyFunc for decrypting.
+func (k *sharesKey) decryptKey(h *header) ([]byte, error) {
+	if h.mirror {
+		return nil, errMirrorFile
+	}
+	if !h.shares {
+		return nil, errors.New("file was not encrypted with shares")
+	}
+	return deriveKey(k.secret, h.salt, sharesInfo), nil
+}
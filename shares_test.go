@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"roseh.moe/pkg/wordlist"
+)
+
+func TestGF(t *testing.T) {
+	t.Parallel()
+
+	for a := range 256 {
+		for b := 1; b < 256; b++ {
+			if got := gfMul(gfDiv(byte(a), byte(b)), byte(b)); got != byte(a) {
+				t.Fatalf("%d / %d * %d = %d, want %d", a, b, b, got, a)
+			}
+		}
+	}
+	// From FIPS 197, section 4.2.
+	if got := gfMul(0x57, 0x83); got != 0xc1 {
+		t.Errorf("gfMul(0x57, 0x83) = %#x, want 0xc1", got)
+	}
+}
+
+func TestSplitSecret(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	for _, tc := range []struct {
+		desc    string
+		k, n    int
+		use     []int // the shares to combine
+		wantErr bool
+	}{
+		{desc: "All", k: 3, n: 5, use: []int{0, 1, 2, 3, 4}},
+		{desc: "First", k: 3, n: 5, use: []int{0, 1, 2}},
+		{desc: "Last", k: 3, n: 5, use: []int{4, 3, 2}},
+		{desc: "Mixed", k: 3, n: 5, use: []int{3, 0, 4}},
+		{desc: "OneOfOne", k: 1, n: 1, use: []int{0}},
+		{desc: "OneOfThree", k: 1, n: 3, use: []int{2}},
+		{desc: "TooFew", k: 3, n: 5, use: []int{0, 1}, wantErr: true},
+		{desc: "Duplicate", k: 2, n: 3, use: []int{1, 1}, wantErr: true},
+		{desc: "None", k: 2, n: 3, wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			shares := splitSecret(secret, tc.k, tc.n)
+			if len(shares) != tc.n {
+				t.Fatalf("splitSecret returned %d shares, want %d", len(shares), tc.n)
+			}
+			var use []*share
+			for _, i := range tc.use {
+				use = append(use, shares[i])
+			}
+			got, err := combineShares(use)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("combineShares returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, secret) {
+				t.Errorf("combineShares returned %q, want %q", got, secret)
+			}
+		})
+	}
+}
+
+func TestCombineShares_DifferentSecrets(t *testing.T) {
+	t.Parallel()
+
+	a := splitSecret(bytes.Repeat([]byte{1}, shareSecretSize), 2, 2)
+	b := splitSecret(bytes.Repeat([]byte{2}, shareSecretSize), 2, 2)
+	if bytes.Equal(a[0].id, b[0].id) {
+		t.Skip("The random IDs of the share sets are the same")
+	}
+	if _, err := combineShares([]*share{a[0], b[1]}); err == nil {
+		t.Error("combineShares succeeded with shares of different secrets")
+	}
+}
+
+func TestSplitSecret_ID(t *testing.T) {
+	t.Parallel()
+
+	// The ID doesn't depend on the secret, so it reveals nothing.
+	secret := bytes.Repeat([]byte{1}, shareSecretSize)
+	ids := make(map[string]bool)
+	for range 10 {
+		ids[string(splitSecret(secret, 2, 2)[0].id)] = true
+	}
+	if len(ids) == 1 {
+		t.Error("splitSecret gave the same ID to every set of shares of a secret")
+	}
+}
+
+func TestShareEncoding(t *testing.T) {
+	t.Parallel()
+
+	// The share is fixed, so that the typo below has the same fix on
+	// every run.
+	sh := &share{threshold: 2, x: 3, id: []byte{0x12, 0x34}, y: bytes.Repeat([]byte{0xa5}, shareSecretSize)}
+	encoded := sh.encode()
+	words := strings.Fields(encoded)
+
+	for _, tc := range []struct {
+		desc    string
+		s       string
+		wantErr string
+	}{{
+		desc: "Valid",
+		s:    encoded,
+	}, {
+		desc: "ExtraSpace",
+		s:    "\n" + strings.ReplaceAll(encoded, " ", "  ") + "\n",
+	}, {
+		desc:    "Typo",
+		s:       mistype(words, 5),
+		wantErr: "did you mean " + `"` + words[5] + `"`,
+	}, {
+		desc:    "WrongWord",
+		s:       strings.Replace(encoded, words[0], wordlist.Words[(wordIndexes()[words[0]]+4096)%len(wordlist.Words)], 1),
+		wantErr: "checksum does not match",
+	}, {
+		desc:    "Short",
+		s:       strings.Join(words[1:], " "),
+		wantErr: "words",
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := decodeShare(tc.s)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("decodeShare returned error %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeShare failed: %s", err)
+			}
+			if got.threshold != sh.threshold || got.x != sh.x || !bytes.Equal(got.id, sh.id) || !bytes.Equal(got.y, sh.y) {
+				t.Errorf("decodeShare returned %+v, want %+v", got, sh)
+			}
+		})
+	}
+}
+
+func TestParseShares(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		s       string
+		k, n    int
+		wantErr bool
+	}{
+		{s: "3/5", k: 3, n: 5},
+		{s: "1/1", k: 1, n: 1},
+		{s: "255/255", k: 255, n: 255},
+		{s: "3", wantErr: true},
+		{s: "5/3", wantErr: true},
+		{s: "0/3", wantErr: true},
+		{s: "2/256", wantErr: true},
+		{s: "a/b", wantErr: true},
+	} {
+		k, n, err := parseShares(tc.s)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("parseShares(%q) returned error %v, want error? %t", tc.s, err, tc.wantErr)
+			continue
+		}
+		if k != tc.k || n != tc.n {
+			t.Errorf("parseShares(%q) = %d, %d, want %d, %d", tc.s, k, n, tc.k, tc.n)
+		}
+	}
+}
+
+func TestShares(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	shareDir := filepath.Join(dir, "shares")
+	if err := os.Mkdir(shareDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	fileContent := []byte("test file content")
+	fileName := filepath.Join(dir, "file")
+	mustWriteFile(t, fileName, fileContent)
+	enc := &encCmd{shares: "2/3", shareDir: shareDir}
+	if err := enc.run(t.Context(), fileName); err != nil {
+		t.Fatalf("enc failed: %s", err)
+	}
+	mustRemove(t, fileName)
+	share := func(i int) string {
+		return filepath.Join(shareDir, fmt.Sprintf("share-%d.txt", i))
+	}
+	fi, err := os.Stat(share(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("Share file has mode %v, want 0600", perm)
+	}
+	share3 := string(mustReadFile(t, share(3)))
+
+	for _, tc := range []struct {
+		desc       string
+		password   string
+		shares     stringsFlag
+		shareFiles stringsFlag
+		wantErr    bool
+	}{{
+		desc:       "Files",
+		shareFiles: stringsFlag{share(1), share(2)},
+	}, {
+		desc:       "WordsAndFile",
+		shares:     stringsFlag{share3},
+		shareFiles: stringsFlag{share(2)},
+	}, {
+		desc:       "TooFew",
+		shareFiles: stringsFlag{share(2)},
+		wantErr:    true,
+	}, {
+		desc:     "Password",
+		password: "asdf",
+		wantErr:  true,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			out := filepath.Join(t.TempDir(), "out")
+			dec := &decCmd{
+				keys: keyFlags{
+					password:   tc.password,
+					shares:     tc.shares,
+					shareFiles: tc.shareFiles,
+					passwordIn: func(string) (string, error) {
+						t.Error("dec prompted for a password")
+						return "", nil
+					},
+				},
+				output: out,
+			}
+			err := dec.run(t.Context(), fileName+".enc")
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("dec returned error %v, want error? %t", err, tc.wantErr)
+			}
+			if err == nil {
+				if got := mustReadFile(t, out); !bytes.Equal(got, fileContent) {
+					t.Errorf("Decrypted file has contents %q, want %q", got, fileContent)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,631 @@
+package main
+
+// The password strength estimate is modeled on zxcvbn
+// (https://github.com/dropbox/zxcvbn). The password is covered with
+// matches of common patterns: words from the word lists in dict/,
+// possibly capitalized, reversed or with l33t substitutions, keyboard
+// walks, sequences, repeats and dates. Each match gets an estimate of
+// the number of guesses it takes to find, and the cover that takes the
+// fewest guesses is the estimate for the whole password. Characters
+// outside any match are guessed by brute force.
+//
+// Guess counts are kept as base 10 logarithms, since they overflow a
+// float64 for long passwords.
+
+import (
+	"embed"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"roseh.moe/pkg/wordlist"
+)
+
+//go:embed dict/*.txt
+var dictFiles embed.FS
+
+const (
+	// maxEstimateLength is the number of characters of the password
+	// the estimate looks at. Longer passwords are strong anyway.
+	maxEstimateLength = 100
+
+	// Minimum number of guesses for a match of one character, and of
+	// more characters, so that covering a password with many small
+	// matches doesn't make it look weaker than brute force.
+	minGuessesSingle = 10
+	minGuessesMulti  = 50
+
+	// minYearSpace is the minimum number of years an attacker is
+	// assumed to try for a date.
+	minYearSpace = 20
+
+	// attackerBandwidth is the memory bandwidth in bytes per second of
+	// the attacker assumed by the crack time estimate, about a thousand
+	// GPUs.
+	attackerBandwidth = 1e15
+
+	// Passwords that would be cracked in less than minCrackTime seconds
+	// are refused, and ones that would take less than warnCrackTime get
+	// a warning.
+	minCrackTime  = 30 * 24 * 60 * 60
+	warnCrackTime = 100 * 365 * 24 * 60 * 60
+)
+
+// dictionaries returns the rank of each word, for each of the bundled
+// word lists.
+var dictionaries = sync.OnceValue(func() []map[string]int {
+	var dicts []map[string]int
+	for _, name := range []string{"passwords", "english", "names"} {
+		b, err := dictFiles.ReadFile("dict/" + name + ".txt")
+		if err != nil {
+			panic(err) // impossible, the file is embedded
+		}
+		d := make(map[string]int)
+		for _, word := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			if _, ok := d[word]; !ok {
+				d[word] = len(d) + 1
+			}
+		}
+		dicts = append(dicts, d)
+	}
+	// Words of generated passphrases are all equally likely.
+	words := make(map[string]int, len(wordlist.Words))
+	for i := range len(wordlist.Words) {
+		words[wordlist.Words[i]] = len(wordlist.Words)
+	}
+	return append(dicts, words)
+})
+
+// l33tTables map l33t characters to the letters they stand for. Some
+// characters stand for more than one letter, so there are two tables.
+var l33tTables = []map[rune]rune{{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '{': 'c', '[': 'c', '<': 'c',
+	'3': 'e', '6': 'g', '9': 'g', '1': 'i', '!': 'i', '|': 'i', '0': 'o',
+	'$': 's', '5': 's', '+': 't', '7': 't', '%': 'x', '2': 'z',
+}, {
+	'1': 'l', '|': 'l', '7': 'l',
+}}
+
+// keyboardRows are the rows of a US keyboard, unshifted and shifted,
+// with the offset of each row from the left in keys.
+var keyboardRows = []struct {
+	keys, shifted string
+	offset        float64
+}{
+	{"`1234567890-=", "~!@#$%^&*()_+", 0},
+	{"qwertyuiop[]\\", "QWERTYUIOP{}|", 1.5},
+	{"asdfghjkl;'", "ASDFGHJKL:\"", 1.75},
+	{"zxcvbnm,./", "ZXCVBNM<>?", 2.25},
+}
+
+type keyPos struct {
+	row     int
+	x       float64
+	shifted bool
+}
+
+type keyboardLayout struct {
+	keys      map[rune]keyPos
+	nKeys     float64 // number of keys
+	avgDegree float64 // average number of neighbors of a key
+}
+
+var keyboard = sync.OnceValue(func() *keyboardLayout {
+	kb := &keyboardLayout{keys: make(map[rune]keyPos)}
+	for row, r := range keyboardRows {
+		for i, c := range r.keys {
+			kb.keys[c] = keyPos{row: row, x: r.offset + float64(i)}
+		}
+		for i, c := range r.shifted {
+			kb.keys[c] = keyPos{row: row, x: r.offset + float64(i), shifted: true}
+		}
+	}
+	var degrees int
+	for _, a := range kb.keys {
+		if a.shifted {
+			continue
+		}
+		kb.nKeys++
+		for _, b := range kb.keys {
+			if !b.shifted && a.adjacent(b) {
+				degrees++
+			}
+		}
+	}
+	kb.avgDegree = float64(degrees) / kb.nKeys
+	return kb
+})
+
+func (a keyPos) adjacent(b keyPos) bool {
+	dx := math.Abs(a.x - b.x)
+	switch a.row - b.row {
+	case 0:
+		return dx == 1
+	case -1, 1:
+		return dx <= 0.75
+	}
+	return false
+}
+
+type passwordMatch struct {
+	i, j int     // the match covers characters i to j, inclusive
+	lg   float64 // log10 of the number of guesses
+}
+
+type strengthEstimator struct {
+	dicts    []map[string]int
+	thisYear int
+
+	// memo holds the estimates for the repeated parts of the password.
+	memo map[string]float64
+}
+
+// estimateGuesses returns the base 10 logarithm of the estimated number
+// of guesses it takes to find password. userInputs are words an
+// attacker would try first, like the names of the files being
+// encrypted.
+func estimateGuesses(password string, userInputs []string) float64 {
+	e := &strengthEstimator{
+		dicts:    dictionaries(),
+		thisYear: time.Now().Year(),
+		memo:     make(map[string]float64),
+	}
+	if len(userInputs) > 0 {
+		inputs := make(map[string]int)
+		for _, s := range userInputs {
+			s = strings.ToLower(s)
+			if _, ok := inputs[s]; !ok {
+				inputs[s] = len(inputs) + 1
+			}
+		}
+		e.dicts = append(slices.Clip(e.dicts), inputs)
+	}
+	pw := []rune(password)
+	if len(pw) > maxEstimateLength {
+		pw = pw[:maxEstimateLength]
+	}
+	return e.guesses(pw)
+}
+
+// guesses returns the estimate for the cover of pw with the fewest
+// guesses.
+func (e *strengthEstimator) guesses(pw []rune) float64 {
+	if len(pw) == 0 {
+		return 0
+	}
+	if lg, ok := e.memo[string(pw)]; ok {
+		return lg
+	}
+	n := len(pw)
+	byEnd := make([][]passwordMatch, n)
+	for _, m := range e.matches(pw) {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+	// best[k][l] is the fewest guesses for the first k characters
+	// covered by l matches.
+	best := make([][]float64, n+1)
+	for k := range best {
+		best[k] = make([]float64, k+1)
+		for l := range best[k] {
+			best[k][l] = math.Inf(1)
+		}
+	}
+	best[0][0] = 0
+	relax := func(i, k int, lg float64) {
+		for l := range best[i] {
+			best[k][l+1] = min(best[k][l+1], best[i][l]+lg)
+		}
+	}
+	for k := 1; k <= n; k++ {
+		for i := range k {
+			relax(i, k, bruteforceGuesses(k-i))
+		}
+		for _, m := range byEnd[k-1] {
+			relax(m.i, k, m.lg)
+		}
+	}
+	lg := math.Inf(1)
+	for l := 1; l <= n; l++ {
+		// The matches can come in any order, and each match beyond
+		// the first adds to the guesses.
+		lgFact, _ := math.Lgamma(float64(l + 1))
+		lg = min(lg, logAdd(best[n][l]+lgFact/math.Ln10, 4*float64(l-1)))
+	}
+	e.memo[string(pw)] = lg
+	return lg
+}
+
+// logAdd returns log10(10^a + 10^b).
+func logAdd(a, b float64) float64 {
+	hi, lo := max(a, b), min(a, b)
+	return hi + math.Log10(1+math.Pow(10, lo-hi))
+}
+
+func bruteforceGuesses(length int) float64 {
+	if length == 1 {
+		return max(1, math.Log10(minGuessesSingle+1))
+	}
+	return max(float64(length), math.Log10(minGuessesMulti+1))
+}
+
+func (e *strengthEstimator) matches(pw []rune) []passwordMatch {
+	var ms []passwordMatch
+	ms = append(ms, e.dictionaryMatches(pw)...)
+	ms = append(ms, spatialMatches(pw)...)
+	ms = append(ms, sequenceMatches(pw)...)
+	ms = append(ms, e.repeatMatches(pw)...)
+	ms = append(ms, e.dateMatches(pw)...)
+	for i := range ms {
+		minGuesses := minGuessesMulti
+		if ms[i].i == ms[i].j {
+			minGuesses = minGuessesSingle
+		}
+		ms[i].lg = max(ms[i].lg, math.Log10(float64(minGuesses)))
+	}
+	return ms
+}
+
+// rank returns the best rank of word in the dictionaries.
+func (e *strengthEstimator) rank(word []rune) (int, bool) {
+	best, found := 0, false
+	for _, d := range e.dicts {
+		if r, ok := d[string(word)]; ok && (!found || r < best) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+func (e *strengthEstimator) dictionaryMatches(pw []rune) []passwordMatch {
+	lower := make([]rune, len(pw))
+	for i, r := range pw {
+		lower[i] = unicode.ToLower(r)
+	}
+	var ms []passwordMatch
+	for i := range lower {
+		for j := i; j < len(lower); j++ {
+			word := lower[i : j+1]
+			upper := math.Log10(uppercaseVariations(pw[i : j+1]))
+			if r, ok := e.rank(word); ok {
+				ms = append(ms, passwordMatch{i, j, math.Log10(float64(r)) + upper})
+			}
+			if j > i {
+				reversed := slices.Clone(word)
+				slices.Reverse(reversed)
+				if r, ok := e.rank(reversed); ok {
+					ms = append(ms, passwordMatch{i, j, math.Log10(float64(2*r)) + upper})
+				}
+			}
+			for _, table := range l33tTables {
+				unl33ted, variations := unl33t(word, table)
+				if variations == 1 {
+					continue
+				}
+				if r, ok := e.rank(unl33ted); ok {
+					ms = append(ms, passwordMatch{i, j, math.Log10(float64(r)*variations) + upper})
+				}
+			}
+		}
+	}
+	return ms
+}
+
+// uppercaseVariations returns the number of ways the letters of word
+// could have been capitalized, counting the common ways as one.
+func uppercaseVariations(word []rune) float64 {
+	var upper, lower int
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 1
+	case lower == 0,
+		upper == 1 && unicode.IsUpper(word[0]),
+		upper == 1 && unicode.IsUpper(word[len(word)-1]):
+		return 2
+	}
+	return variations(upper, lower)
+}
+
+// variations returns the number of ways of choosing up to min(a, b)
+// of a+b characters, at least one.
+func variations(a, b int) float64 {
+	var v float64
+	for k := 1; k <= min(a, b); k++ {
+		v += binomial(a+b, k)
+	}
+	return v
+}
+
+func binomial(n, k int) float64 {
+	r := 1.0
+	for i := 1; i <= k; i++ {
+		r = r * float64(n-k+i) / float64(i)
+	}
+	return r
+}
+
+// unl33t undoes the substitutions of table in word, and returns the
+// number of ways the substitutions could have been made.
+func unl33t(word []rune, table map[rune]rune) ([]rune, float64) {
+	out := make([]rune, len(word))
+	subbed := make(map[rune]int)
+	for i, r := range word {
+		out[i] = r
+		if c, ok := table[r]; ok {
+			out[i] = c
+			subbed[c]++
+		}
+	}
+	v := 1.0
+	for c, s := range subbed {
+		u := 0
+		for _, r := range word {
+			if r == c {
+				u++
+			}
+		}
+		if u == 0 {
+			v *= 2
+		} else {
+			v *= variations(s, u)
+		}
+	}
+	return out, v
+}
+
+// spatialMatches finds walks of at least three adjacent keys on the
+// keyboard.
+func spatialMatches(pw []rune) []passwordMatch {
+	kb := keyboard()
+	var ms []passwordMatch
+	for i := 0; i < len(pw); {
+		j, turns := i, 0
+		var dir [2]float64
+		for ; j+1 < len(pw); j++ {
+			a, okA := kb.keys[pw[j]]
+			b, okB := kb.keys[pw[j+1]]
+			if !okA || !okB || !a.adjacent(b) {
+				break
+			}
+			d := [2]float64{b.x - a.x, float64(b.row - a.row)}
+			if j == i || d != dir {
+				turns++
+			}
+			dir = d
+		}
+		if length := j - i + 1; length >= 3 {
+			shifted := 0
+			for _, r := range pw[i : j+1] {
+				if kb.keys[r].shifted {
+					shifted++
+				}
+			}
+			ms = append(ms, passwordMatch{i, j, kb.guesses(length, turns, shifted)})
+		}
+		i = j + 1
+	}
+	return ms
+}
+
+func (kb *keyboardLayout) guesses(length, turns, shifted int) float64 {
+	var g float64
+	for i := 2; i <= length; i++ {
+		for t := 1; t <= min(turns, i-1); t++ {
+			g += binomial(i-1, t-1) * kb.nKeys * math.Pow(kb.avgDegree, float64(t))
+		}
+	}
+	if shifted > 0 {
+		if unshifted := length - shifted; unshifted == 0 {
+			g *= 2
+		} else {
+			g *= variations(shifted, unshifted)
+		}
+	}
+	return math.Log10(g)
+}
+
+// sequenceMatches finds runs of at least three characters with the same
+// small difference between them, like "abc", "7531" or "zyx".
+func sequenceMatches(pw []rune) []passwordMatch {
+	var ms []passwordMatch
+	for i := 0; i+2 < len(pw); {
+		delta := pw[i+1] - pw[i]
+		j := i + 1
+		for j+1 < len(pw) && pw[j+1]-pw[j] == delta {
+			j++
+		}
+		if length := j - i + 1; length >= 3 && delta != 0 && delta >= -5 && delta <= 5 {
+			var base float64
+			switch first := pw[i]; {
+			case strings.ContainsRune("aAzZ019", first):
+				base = 4
+			case unicode.IsDigit(first):
+				base = 10
+			default:
+				base = 26
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			ms = append(ms, passwordMatch{i, j, math.Log10(base * float64(length))})
+		}
+		i = j
+	}
+	return ms
+}
+
+// repeatMatches finds parts of the password repeated at least twice,
+// like "aaa" or "abcabc".
+func (e *strengthEstimator) repeatMatches(pw []rune) []passwordMatch {
+	var ms []passwordMatch
+	for i := range pw {
+		for unit := 1; i+2*unit <= len(pw); unit++ {
+			k := 1
+			for i+(k+1)*unit <= len(pw) && slices.Equal(pw[i:i+unit], pw[i+k*unit:i+(k+1)*unit]) {
+				k++
+			}
+			if k >= 2 {
+				lg := e.guesses(pw[i:i+unit]) + math.Log10(float64(k))
+				ms = append(ms, passwordMatch{i, i + k*unit - 1, lg})
+			}
+		}
+	}
+	return ms
+}
+
+// dateMatches finds years, and dates with or without separators, like
+// "1987", "13.5.1987" or "19870513".
+func (e *strengthEstimator) dateMatches(pw []rune) []passwordMatch {
+	isDigit := func(r rune) bool { return '0' <= r && r <= '9' }
+	digits := func(i, n int) bool {
+		return i+n <= len(pw) && !slices.ContainsFunc(pw[i:i+n], func(r rune) bool { return !isDigit(r) })
+	}
+	var ms []passwordMatch
+	addDate := func(i, j int, parts [3]string, sep bool) {
+		year, ok := dateYear(parts)
+		if !ok {
+			return
+		}
+		g := 365 * float64(max(abs(year-e.thisYear), minYearSpace))
+		if sep {
+			g *= 4
+		}
+		ms = append(ms, passwordMatch{i, j, math.Log10(g)})
+	}
+	for i := range pw {
+		if digits(i, 4) {
+			if year, ok := parseYear(string(pw[i : i+4])); ok {
+				ms = append(ms, passwordMatch{i, i + 3, math.Log10(float64(max(abs(year-e.thisYear), minYearSpace)))})
+			}
+		}
+		for _, split := range [][3]int{{2, 2, 2}, {4, 2, 2}, {2, 2, 4}} {
+			n := split[0] + split[1] + split[2]
+			if !digits(i, n) {
+				continue
+			}
+			s := string(pw[i : i+n])
+			addDate(i, i+n-1, [3]string{s[:split[0]], s[split[0] : split[0]+split[1]], s[split[0]+split[1]:]}, false)
+		}
+		// Dates with separators: up to four digits, a separator, one or
+		// two digits, the same separator, and up to four digits.
+		var parts [3]string
+		var sep rune
+		j := i
+		for p := range parts {
+			start := j
+			for j < len(pw) && j-start < 4 && isDigit(pw[j]) {
+				j++
+			}
+			if j == start {
+				break
+			}
+			parts[p] = string(pw[start:j])
+			if p == 2 {
+				addDate(i, j-1, parts, true)
+				break
+			}
+			if j == len(pw) || !strings.ContainsRune(" /\\_.-", pw[j]) || (p == 1 && pw[j] != sep) {
+				break
+			}
+			sep = pw[j]
+			j++
+		}
+	}
+	return ms
+}
+
+// dateYear returns the year of the date made of parts, with the year
+// first or last.
+func dateYear(parts [3]string) (int, bool) {
+	// Year, month, day; day, month, year; and month, day, year.
+	for _, order := range [][3]int{{0, 1, 2}, {2, 1, 0}, {2, 0, 1}} {
+		year, ok := parseYear(parts[order[0]])
+		if !ok || len(parts[order[1]]) > 2 || len(parts[order[2]]) > 2 {
+			continue
+		}
+		month, _ := strconv.Atoi(parts[order[1]])
+		day, _ := strconv.Atoi(parts[order[2]])
+		if 1 <= month && month <= 12 && 1 <= day && day <= 31 {
+			return year, true
+		}
+	}
+	return 0, false
+}
+
+// parseYear parses a year with two or four digits.
+func parseYear(s string) (int, bool) {
+	year, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+	switch len(s) {
+	case 2:
+		if year > 50 {
+			return 1900 + year, true
+		}
+		return 2000 + year, true
+	case 4:
+		return year, 1000 <= year && year <= 2050
+	}
+	return 0, false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// crackTime returns the estimated time in seconds it takes the assumed
+// attacker to make 10^lg guesses against the configured Argon2 cost.
+// One pass of Argon2id writes its memory once and reads it twice.
+func crackTime(lg float64) float64 {
+	guessesPerSecond := attackerBandwidth / (3 * 1024 * float64(argon2Memory))
+	return math.Pow(10, lg) / guessesPerSecond
+}
+
+func formatCrackTime(seconds float64) string {
+	const (
+		minute  = 60
+		hour    = 60 * minute
+		day     = 24 * hour
+		year    = 365 * day
+		month   = year / 12
+		century = 100 * year
+	)
+	plural := func(n float64, unit string) string {
+		if k := math.Round(n); k != 1 {
+			return fmt.Sprintf("%.0f %ss", k, unit)
+		}
+		return "1 " + unit
+	}
+	switch {
+	case seconds < 1:
+		return "less than a second"
+	case seconds < minute:
+		return plural(seconds, "second")
+	case seconds < hour:
+		return plural(seconds/minute, "minute")
+	case seconds < day:
+		return plural(seconds/hour, "hour")
+	case seconds < month:
+		return plural(seconds/day, "day")
+	case seconds < year:
+		return plural(seconds/month, "month")
+	case seconds < century:
+		return plural(seconds/year, "year")
+	}
+	return "centuries"
+}
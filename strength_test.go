@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func TestEstimateGuesses(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc       string
+		password   string
+		userInputs []string
+		// The estimate should be between wantMin and wantMax, as
+		// log10 of the number of guesses.
+		wantMin, wantMax float64
+	}{{
+		desc:     "CommonPassword",
+		password: "password",
+		wantMax:  3,
+	}, {
+		desc:     "L33t",
+		password: "P@ssw0rd",
+		wantMax:  3,
+	}, {
+		desc:     "Reversed",
+		password: "drowssap",
+		wantMax:  3,
+	}, {
+		desc:     "Keyboard",
+		password: "qwertyuiop",
+		wantMax:  4,
+	}, {
+		desc:     "Sequence",
+		password: "abcdef",
+		wantMax:  3,
+	}, {
+		desc:     "Repeat",
+		password: "xyzxyzxyz",
+		wantMax:  4,
+	}, {
+		desc:     "Date",
+		password: "13.5.1987",
+		wantMax:  6,
+	}, {
+		desc:       "UserInput",
+		password:   "zorblax2024",
+		userInputs: []string{"zorblax"},
+		wantMax:    5,
+	}, {
+		desc:     "Random",
+		password: "kq7#Zv9!mW2@xR5$pL8&",
+		wantMin:  18,
+		wantMax:  100,
+	}, {
+		desc:     "Passphrase",
+		password: "correct horse battery staple",
+		wantMin:  15,
+		wantMax:  100,
+	}} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got := estimateGuesses(tc.password, tc.userInputs)
+			if got < tc.wantMin || got > tc.wantMax {
+				t.Errorf("estimateGuesses(%q) = %.2f, want between %.0f and %.0f", tc.password, got, tc.wantMin, tc.wantMax)
+			}
+		})
+	}
+}
+
+func TestFormatCrackTime(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		seconds float64
+		want    string
+	}{
+		{0.5, "less than a second"},
+		{1, "1 second"},
+		{90, "2 minutes"},
+		{3 * 24 * 60 * 60, "3 days"},
+		{2 * 365 * 24 * 60 * 60, "2 years"},
+		{1e12, "centuries"},
+	} {
+		if got := formatCrackTime(tc.seconds); got != tc.want {
+			t.Errorf("formatCrackTime(%g) = %q, want %q", tc.seconds, got, tc.want)
+		}
+	}
+}
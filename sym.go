@@ -1,9 +1,12 @@
 // The sym command encrypts or decrypts files with a password.
 //
-// Sym has two subcommands, enc and dec, which perform encryption and
-// decryption. The encryption key is derived from the user's password
-// using argon2, and the data is then encrypted using ChaCha20-Poly1305
-// in chunks of 1MiB.
+// Sym has two main subcommands, enc and dec, which perform encryption
+// and decryption. Directories can be encrypted into a single archive,
+// whose contents can be listed with the ls subcommand.
+//
+// The encryption key is derived from the user's password using argon2,
+// and the data is then encrypted using ChaCha20-Poly1305 in chunks of
+// 1MiB.
 //
 // Run sym -h for detailed usage information.
 //
@@ -21,12 +24,18 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/google/subcommands"
 )
 
 var errUsage = errors.New("usage error")
 
+// exitInterrupted is the exit status after SIGINT or SIGTERM.
+const exitInterrupted subcommands.ExitStatus = 130
+
 type usageError struct {
 	msg string
 }
@@ -41,26 +50,122 @@ func (e *usageError) Error() string {
 
 func (e *usageError) Is(target error) bool { return target == errUsage }
 
-func registerCommands(commander *subcommands.Commander, passwordIn func() (string, error), passwordOut io.Writer, stdin io.Reader, stdout io.Writer) {
+// exitStatus prints err, if any, and returns the exit status for it.
+func exitStatus(ctx context.Context, err error) subcommands.ExitStatus {
+	if err == nil {
+		return subcommands.ExitSuccess
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "sym: interrupted")
+		return exitInterrupted
+	}
+	fmt.Fprintf(os.Stderr, "sym: %s\n", err)
+	if errors.Is(err, errUsage) {
+		return subcommands.ExitUsageError
+	}
+	return subcommands.ExitFailure
+}
+
+// stringsFlag is a flag that can be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseAfterCommand parses the options in args that come after the
+// command of a subcommand like "vault get", with the flags of f. It
+// returns the command followed by the other arguments.
+func parseAfterCommand(f *flag.FlagSet, args []string) ([]string, error) {
+	fs := flag.NewFlagSet(f.Name()+" "+args[0], flag.ContinueOnError)
+	fs.Usage = f.Usage
+	f.VisitAll(func(fl *flag.Flag) {
+		fs.Var(fl.Value, fl.Name, fl.Usage)
+	})
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	return append([]string{args[0]}, fs.Args()...), nil
+}
+
+func registerCommands(commander *subcommands.Commander, passwordIn func(prompt string) (string, error), passwordOut io.Writer, stdin io.Reader, stdout io.Writer) {
+	agent := newAgentClient(os.Getenv(agentSockEnv))
 	commander.Register(&encCmd{
+		agent:       agent,
 		passwordIn:  passwordIn,
 		passwordOut: passwordOut,
 		stdin:       stdin,
 		stdout:      stdout,
 	}, "")
 	commander.Register(&decCmd{
+		keys:   keyFlags{agent: agent, passwordIn: passwordIn},
+		stdin:  stdin,
+		stdout: stdout,
+	}, "")
+	commander.Register(&catCmd{
+		keys:   keyFlags{agent: agent, passwordIn: passwordIn},
+		stdout: stdout,
+	}, "")
+	commander.Register(&editCmd{
+		keys: keyFlags{agent: agent, passwordIn: passwordIn},
+	}, "")
+	commander.Register(&execCmd{
+		keys: keyFlags{agent: agent, passwordIn: passwordIn},
+		exec: execCommand,
+	}, "")
+	commander.Register(&lsCmd{
+		keys:   keyFlags{agent: agent, passwordIn: passwordIn},
+		stdin:  stdin,
+		stdout: stdout,
+	}, "")
+	commander.Register(&syncCmd{
+		keys:   keyFlags{agent: agent, passwordIn: passwordIn},
+		stdout: stdout,
+	}, "")
+	commander.Register(&vaultCmd{
+		keys:   keyFlags{agent: agent, passwordIn: passwordIn},
+		stdin:  stdin,
+		stdout: stdout,
+	}, "")
+	commander.Register(&keygenCmd{}, "")
+	commander.Register(&genpassCmd{
+		stdout: stdout,
+	}, "")
+	commander.Register(&paperkeyCmd{
 		passwordIn: passwordIn,
 		stdin:      stdin,
 		stdout:     stdout,
 	}, "")
+	commander.Register(&blocklistCmd{
+		stdin: stdin,
+	}, "")
+	commander.Register(&agentCmd{
+		stdout: stdout,
+	}, "")
 	commander.Register(commander.HelpCommand(), "")
 	commander.Explain = func(w io.Writer) {
 		fmt.Fprintf(w, `usage: sym <subcommand> [OPTION]... [FILE]...
 Encrypt or decrypt files using a password.
 
 Subcommands:
-  enc    encrypt
-  dec    decrypt
+  enc       encrypt
+  dec       decrypt
+  cat       decrypt files to stdout
+  edit      edit an encrypted file
+  exec      run a command with variables from an encrypted dotenv file
+  ls        list the contents of an encrypted archive
+  sync      incrementally update an encrypted tree
+  vault     keep named secrets in an encrypted file
+  keygen    generate a keyfile
+  genpass   generate a passphrase
+  paperkey  print a key or passphrase for offline backup
+  blocklist build a blocklist of breached passwords
+  agent     remember the password for a while
 
 Try sym <subcommand> -h for command-specific help.
 `)
@@ -68,8 +173,14 @@ Try sym <subcommand> -h for command-specific help.
 }
 
 func main() {
-	ctx := context.Background()
-	registerCommands(subcommands.DefaultCommander, termReadPassword, os.Stderr, os.Stdin, os.Stdout)
+	// Cancel on SIGINT or SIGTERM, so that partial output files can be
+	// cleaned up before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	passwordIn := func(prompt string) (string, error) {
+		return termReadPassword(ctx, prompt)
+	}
+	registerCommands(subcommands.DefaultCommander, passwordIn, os.Stderr, os.Stdin, os.Stdout)
 	flag.Parse()
 	os.Exit(int(subcommands.Execute(ctx)))
 }
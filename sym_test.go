@@ -63,11 +63,11 @@ func TestEncryptDecrypt(t *testing.T) {
 	fileName := filepath.Join(t.TempDir(), "file")
 	mustWriteFile(t, fileName, buf)
 	const password = "karp cache tidal mars fed rajah uses graze pobox flew"
-	if err := (&encCmd{}).encryptFile(fileName, password); err != nil {
+	if err := (&encCmd{}).encryptFile(t.Context(), fileName, passwordKey(password)); err != nil {
 		t.Fatalf("EncryptFile failed: %s", err)
 	}
 	mustRemove(t, fileName)
-	if err := (&decCmd{}).decryptFile(fileName+".enc", password); err != nil {
+	if err := (&decCmd{}).decryptFile(t.Context(), fileName+".enc", passwordKey(password)); err != nil {
 		t.Fatalf("DecryptFile failed: %s", err)
 	}
 	gotContents := mustReadFile(t, fileName)
@@ -96,7 +96,7 @@ func TestCommander(t *testing.T) {
 	const fileContent = "test file content"
 	mustWriteFile(t, fileName, []byte(fileContent))
 	const password = "asdf"
-	if st := run(ctx, t, "enc", "-p="+password, fileName); st != subcommands.ExitSuccess {
+	if st := run(ctx, t, "enc", "-allow-weak", "-p="+password, fileName); st != subcommands.ExitSuccess {
 		t.Fatalf("enc failed: status %d", st)
 	}
 	mustRemove(t, fileName)
@@ -122,11 +122,11 @@ func TestCommander_Errors(t *testing.T) {
 		wantStatus: subcommands.ExitUsageError,
 	}, {
 		desc:       "EncNoSuchFile",
-		cmd:        []string{"enc", "-p=asdf", "nonexistent-file.txt"},
+		cmd:        []string{"enc", "-allow-weak", "-p=asdf", "nonexistent-file.txt"},
 		wantStatus: subcommands.ExitFailure,
 	}, {
 		desc:       "DecUsageError",
-		cmd:        []string{"dec"},
+		cmd:        []string{"dec", "-x=file"},
 		wantStatus: subcommands.ExitUsageError,
 	}, {
 		desc:       "DecNoSuchFile",
@@ -145,6 +145,18 @@ func TestCommander_Errors(t *testing.T) {
 	}
 }
 
+func TestCommander_Interrupted(t *testing.T) {
+	t.Parallel()
+
+	fileName := filepath.Join(t.TempDir(), "file.txt")
+	mustWriteFile(t, fileName, []byte("test file content"))
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if st := run(ctx, t, "enc", "-allow-weak", "-p=asdf", fileName); st != exitInterrupted {
+		t.Errorf("enc returned status %d after interrupt, want %d", st, exitInterrupted)
+	}
+}
+
 func TestUsage(t *testing.T) {
 	t.Parallel()
 
@@ -152,4 +164,15 @@ func TestUsage(t *testing.T) {
 	run(ctx, t, "help")
 	run(ctx, t, "enc", "-h")
 	run(ctx, t, "dec", "-h")
+	run(ctx, t, "cat", "-h")
+	run(ctx, t, "edit", "-h")
+	run(ctx, t, "exec", "-h")
+	run(ctx, t, "ls", "-h")
+	run(ctx, t, "sync", "-h")
+	run(ctx, t, "vault", "-h")
+	run(ctx, t, "keygen", "-h")
+	run(ctx, t, "genpass", "-h")
+	run(ctx, t, "paperkey", "-h")
+	run(ctx, t, "blocklist", "-h")
+	run(ctx, t, "agent", "-h")
 }
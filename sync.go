@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+// mirrorStateFile is the encrypted index of the files in an encrypted
+// tree, kept by sync to find out which files have changed.
+const mirrorStateFile = ".sym-state"
+
+type syncCmd struct {
+	keys      keyFlags
+	delete    bool
+	allowWeak bool
+
+	stdout io.Writer
+}
+
+func (*syncCmd) Name() string     { return "sync" }
+func (*syncCmd) Synopsis() string { return "incrementally update an encrypted tree" }
+func (*syncCmd) Usage() string {
+	return `usage: sym sync [OPTION]... SRC DST
+Update the encrypted tree DST to match the directory SRC, like
+sym enc -mirror, but only encrypt files that are new or have changed
+since the last sync.
+
+DST keeps an encrypted index of the size, modification time and
+content hash of each file. Files whose size and modification time
+haven't changed are skipped without being read.
+
+When DST is not an encrypted tree yet, the password is checked like
+with sym enc, and very weak passwords are refused unless -allow-weak is
+given.
+
+` + passwordSourceUsage + "\n"
+}
+
+func (c *syncCmd) SetFlags(fs *flag.FlagSet) {
+	c.keys.setFlags(fs, "sync")
+	fs.BoolVar(&c.delete, "delete", false, "delete encrypted files whose source file has been removed")
+	fs.BoolVar(&c.allowWeak, "allow-weak", false, "create the encrypted tree even if the password is very weak")
+}
+
+type syncEntry struct {
+	Dir     bool      `json:"dir,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime,omitzero"`
+	Hash    []byte    `json:"hash,omitempty"`
+}
+
+type syncStats struct {
+	added, updated, unchanged, deleted int
+}
+
+type syncer struct {
+	ctx      context.Context
+	src, dst string
+	m        *mirror
+	state    map[string]*syncEntry
+	seen     map[string]bool
+	stats    syncStats
+}
+
+func (s *syncer) loadState() error {
+	s.state = make(map[string]*syncEntry)
+	f, err := os.Open(filepath.Join(s.dst, mirrorStateFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(newKeyedDecryptingReader(s.ctx, f, s.m.key)).Decode(&s.state); err != nil {
+		return fmt.Errorf("read %q: %s", f.Name(), err)
+	}
+	return nil
+}
+
+func (s *syncer) saveState() error {
+	buf := new(bytes.Buffer)
+	w := newKeyedEncryptingWriter(s.ctx, buf, s.m.key)
+	w.header.mirror = true
+	if err := json.NewEncoder(w).Encode(s.state); err != nil {
+		return err
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dst, mirrorStateFile), buf.Bytes())
+}
+
+func hashFile(fileName string) ([]byte, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func (s *syncer) syncFile(rel string, d fs.DirEntry) error {
+	s.seen[rel] = true
+	encRel, err := s.m.encryptPath(rel)
+	if err != nil {
+		return err
+	}
+	out := filepath.Join(s.dst, filepath.FromSlash(encRel))
+	if d.IsDir() {
+		if err := os.Mkdir(out, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		s.state[rel] = &syncEntry{Dir: true}
+		return nil
+	}
+	fi, err := d.Info()
+	if err != nil {
+		return err
+	}
+	src := filepath.Join(s.src, filepath.FromSlash(rel))
+	old := s.state[rel]
+	_, statErr := os.Stat(out)
+	upToDate := old != nil && !old.Dir && statErr == nil
+	if upToDate && old.Size == fi.Size() && old.ModTime.Equal(fi.ModTime()) {
+		s.stats.unchanged++
+		return nil
+	}
+	if upToDate && old.Size == fi.Size() {
+		// Only the modification time changed, check the content.
+		h, err := hashFile(src)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(h, old.Hash) {
+			old.ModTime = fi.ModTime()
+			s.stats.unchanged++
+			return os.Chtimes(out, fi.ModTime(), fi.ModTime())
+		}
+	}
+	h := sha256.New()
+	if err := s.m.encryptFile(s.ctx, src, out, true, h); err != nil {
+		return err
+	}
+	s.state[rel] = &syncEntry{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Hash:    h.Sum(nil),
+	}
+	if old == nil {
+		s.stats.added++
+	} else {
+		s.stats.updated++
+	}
+	return nil
+}
+
+// deleteRemoved deletes the encrypted files and directories whose
+// source no longer exists.
+func (s *syncer) deleteRemoved() error {
+	// Delete the deepest paths first, so directories are empty.
+	for _, rel := range slices.Backward(slices.Sorted(maps.Keys(s.state))) {
+		if s.seen[rel] {
+			continue
+		}
+		encRel, err := s.m.encryptPath(rel)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(s.dst, filepath.FromSlash(encRel))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if !s.state[rel].Dir {
+			s.stats.deleted++
+		}
+		delete(s.state, rel)
+	}
+	return nil
+}
+
+// syncTree updates the encrypted tree dst, opened as m, to match src.
+func syncTree(ctx context.Context, m *mirror, src, dst string, deleteRemoved bool) (syncStats, error) {
+	s := &syncer{
+		ctx:  ctx,
+		src:  src,
+		dst:  dst,
+		m:    m,
+		seen: make(map[string]bool),
+	}
+	if err := s.loadState(); err != nil {
+		return syncStats{}, err
+	}
+	err := walkMirror(src, dst, s.syncFile)
+	if err == nil && deleteRemoved {
+		err = s.deleteRemoved()
+	}
+	// Save the progress even if something failed.
+	if saveErr := s.saveState(); err == nil {
+		err = saveErr
+	}
+	return s.stats, err
+}
+
+// open opens the encrypted tree dst, or creates it with a new key.
+func (c *syncCmd) open(ctx context.Context, dst string) (*mirror, error) {
+	keyFile := filepath.Join(dst, mirrorKeyFile)
+	if _, err := os.Stat(keyFile); errors.Is(err, fs.ErrNotExist) {
+		if len(c.keys.shares) > 0 || len(c.keys.shareFiles) > 0 {
+			return nil, usageErr("a new encrypted tree cannot be created with -share")
+		}
+		key, err := c.keys.newKey([]string{keyFile}, c.allowWeak)
+		if err != nil {
+			return nil, err
+		}
+		return openMirror(ctx, dst, key, true)
+	}
+	key, decrypted, err := c.keys.key([]string{keyFile})
+	if err != nil {
+		return nil, err
+	}
+	m, err := openMirror(ctx, dst, key, false)
+	if err != nil {
+		// The master key could not be decrypted.
+		if ctx.Err() == nil {
+			c.keys.pwSource.reportFailure(err)
+		}
+		return nil, err
+	}
+	decrypted()
+	return m, nil
+}
+
+func (c *syncCmd) run(ctx context.Context, args ...string) error {
+	if len(args) != 2 {
+		return usageErr("sync requires a source and a destination directory")
+	}
+	if err := c.keys.check(); err != nil {
+		return err
+	}
+	m, err := c.open(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	stats, err := syncTree(ctx, m, args[0], args[1], c.delete)
+	fmt.Fprintf(c.stdout, "%d added, %d updated, %d unchanged, %d deleted\n", stats.added, stats.updated, stats.unchanged, stats.deleted)
+	return err
+}
+
+func (c *syncCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return exitStatus(ctx, c.run(ctx, f.Args()...))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncTree(t *testing.T) {
+	t.Parallel()
+
+	const password = "asdf"
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	mustMkdir(t, src)
+	mustMkdir(t, filepath.Join(src, "dir"))
+	mustWriteFile(t, filepath.Join(src, "a"), []byte("a"))
+	mustWriteFile(t, filepath.Join(src, "dir", "b"), []byte("b"))
+	mustWriteFile(t, filepath.Join(src, "c"), []byte("c"))
+
+	for _, step := range []struct {
+		desc   string
+		change func()
+		delete bool
+		want   syncStats
+	}{{
+		desc: "Initial",
+		want: syncStats{added: 3},
+	}, {
+		desc: "NoChanges",
+		want: syncStats{unchanged: 3},
+	}, {
+		desc: "Modified",
+		change: func() {
+			mustWriteFile(t, filepath.Join(src, "a"), []byte("changed"))
+		},
+		want: syncStats{updated: 1, unchanged: 2},
+	}, {
+		desc: "Touched",
+		change: func() {
+			mtime := time.Now().Add(time.Hour)
+			if err := os.Chtimes(filepath.Join(src, "c"), mtime, mtime); err != nil {
+				t.Fatalf("Failed to set mtime: %s", err)
+			}
+		},
+		want: syncStats{unchanged: 3},
+	}, {
+		desc: "RemovedWithoutDelete",
+		change: func() {
+			if err := os.RemoveAll(filepath.Join(src, "dir")); err != nil {
+				t.Fatalf("Failed to remove directory: %s", err)
+			}
+		},
+		want: syncStats{unchanged: 2},
+	}, {
+		desc:   "RemovedWithDelete",
+		delete: true,
+		want:   syncStats{unchanged: 2, deleted: 1},
+	}} {
+		if step.change != nil {
+			step.change()
+		}
+		m, err := openMirror(t.Context(), dst, passwordKey(password), true)
+		if err != nil {
+			t.Fatalf("%s: openMirror failed: %s", step.desc, err)
+		}
+		got, err := syncTree(t.Context(), m, src, dst, step.delete)
+		if err != nil {
+			t.Fatalf("%s: syncTree failed: %s", step.desc, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: syncTree returned %+v, want %+v", step.desc, got, step.want)
+		}
+	}
+
+	restored := filepath.Join(t.TempDir(), "restored")
+	m, err := openMirror(t.Context(), dst, passwordKey(password), false)
+	if err != nil {
+		t.Fatalf("openMirror failed: %s", err)
+	}
+	if err := m.decryptTree(t.Context(), dst, restored, false); err != nil {
+		t.Fatalf("decryptTree failed: %s", err)
+	}
+	if got := mustReadFile(t, filepath.Join(restored, "a")); string(got) != "changed" {
+		t.Errorf("Restored file has content %q, want %q", got, "changed")
+	}
+	if _, err := os.Stat(filepath.Join(restored, "dir")); !os.IsNotExist(err) {
+		t.Errorf("Deleted directory was restored")
+	}
+}
+
+func TestSyncCmd_Run_Keyfile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	keyfile := filepath.Join(dir, "key")
+	mustMkdir(t, src)
+	mustWriteFile(t, filepath.Join(src, "a"), []byte("a"))
+	mustWriteFile(t, keyfile, []byte("keyfile contents"))
+	c := &syncCmd{
+		keys:      keyFlags{password: "asdf", keyfiles: stringsFlag{keyfile}},
+		allowWeak: true,
+		stdout:    new(strings.Builder),
+	}
+	for range 2 {
+		if err := c.run(t.Context(), src, dst); err != nil {
+			t.Fatalf("sync failed: %s", err)
+		}
+	}
+	c.keys.keyfiles = nil
+	if err := c.run(t.Context(), src, dst); err == nil {
+		t.Error("sync succeeded without the keyfile")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// openTTY opens the console for reading passwords. Prompts are written
+// to stderr.
+func openTTY() (*os.File, io.Writer, error) {
+	tty, err := os.Open("CONIN$")
+	if err != nil {
+		return nil, nil, err
+	}
+	return tty, os.Stderr, nil
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// openTTY opens the controlling terminal, for reading passwords and
+// writing prompts.
+func openTTY() (*os.File, io.Writer, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tty, tty, nil
+}
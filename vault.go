@@ -0,0 +1,417 @@
+package main
+
+// A vault is an encrypted file holding named secrets. Its plaintext is
+// a dotenv file with the secrets sorted by name, so that sym dec and
+// sym exec -env can read it too. Every update rewrites the whole file
+// with a new salt, using an output file so that the vault is replaced
+// atomically. Updates hold a lock on a file next to the vault from
+// reading the vault until replacing it, so that concurrent updates
+// don't lose each other's changes.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/subcommands"
+)
+
+// vaultEnv is the environment variable with the default vault file.
+const vaultEnv = "SYM_VAULT"
+
+type vaultCmd struct {
+	file      string
+	keys      keyFlags
+	allowWeak bool
+
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func (*vaultCmd) Name() string     { return "vault" }
+func (*vaultCmd) Synopsis() string { return "keep named secrets in an encrypted file" }
+func (*vaultCmd) Usage() string {
+	return `usage: sym vault [OPTION]... COMMAND [ARG]...
+Keep many small secrets in a single encrypted file. The commands are:
+  set NAME          set the secret NAME to a value read from stdin, or
+                    from the terminal
+  get NAME          print the secret NAME
+  list              list the names of the secrets
+  rm NAME...        remove secrets
+  import [FILE]...  add the secrets of dotenv files, or stdin
+  export            print all secrets as a dotenv file
+Example:
+  sym vault set github-token < token.txt
+  curl -H "Authorization: token $(sym vault get github-token)" ...
+
+The vault is the file given with -vault, or $SYM_VAULT, or vault.enc in
+the sym directory of the user's configuration directory. set and import
+create it if it doesn't exist. Names can have letters, digits, _, . and
+-, and don't start with a digit. With set, a single newline at the end
+of stdin is dropped.
+
+The vault is a dotenv file encrypted like with sym enc, so sym dec can
+decrypt it, and sym exec -env can use it. Each update rewrites the file
+atomically, and waits for other updates to finish, using a .lock file
+next to the vault. If the vault is a symlink, the file it points to is
+updated. Use sym agent so that the password is asked only once, and
+reading the vault doesn't hash the password again.
+
+` + passwordSourceUsage + "\n"
+}
+
+// defaultVaultFile returns the vault file to use if -vault isn't given.
+func defaultVaultFile() string {
+	if file := os.Getenv(vaultEnv); file != "" {
+		return file
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sym", "vault.enc")
+}
+
+func (c *vaultCmd) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.file, "vault", defaultVaultFile(), "use the vault `file`")
+	c.keys.setFlags(fs, "vault")
+	fs.BoolVar(&c.allowWeak, "allow-weak", false, "create the vault even if the password is very weak")
+}
+
+type vault struct {
+	secrets map[string]string
+	// key is the key function for writing the vault.
+	key keyFunc
+	// salt is the salt of the vault when it was read, or nil for a new
+	// vault.
+	salt []byte
+}
+
+// vars returns the secrets sorted by name.
+func (v *vault) vars() []envVar {
+	var vars []envVar
+	for _, name := range slices.Sorted(maps.Keys(v.secrets)) {
+		vars = append(vars, envVar{name: name, value: v.secrets[name]})
+	}
+	return vars
+}
+
+// vaultSalt returns the salt of the vault file, or nil if it doesn't
+// exist.
+func vaultSalt(fileName string) ([]byte, error) {
+	f, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, err := readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	return h.salt, nil
+}
+
+// newKey returns the key function for a new vault.
+func (c *vaultCmd) newKey() (keyFunc, error) {
+	if len(c.keys.shares) > 0 || len(c.keys.shareFiles) > 0 {
+		return nil, usageErr("a new vault cannot be created with -share")
+	}
+	return c.keys.newKey([]string{c.file}, c.allowWeak)
+}
+
+// open decrypts the vault. If it doesn't exist and create is set, an
+// empty vault is returned.
+func (c *vaultCmd) open(ctx context.Context, create bool) (*vault, error) {
+	if _, err := os.Stat(c.file); errors.Is(err, fs.ErrNotExist) {
+		if !create {
+			return nil, fmt.Errorf("vault %q does not exist (use sym vault set to create it)", c.file)
+		}
+		key, err := c.newKey()
+		if err != nil {
+			return nil, err
+		}
+		return &vault{secrets: make(map[string]string), key: key}, nil
+	}
+	key, decrypted, err := c.keys.key([]string{c.file})
+	if err != nil {
+		return nil, err
+	}
+	reader, plaintext, err := decryptFile(ctx, c.file, key)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.keys.pwSource.reportFailure(err)
+		}
+		return nil, fmt.Errorf("decrypt %q: %s", c.file, err)
+	}
+	decrypted()
+	vars, err := parseDotenv(string(plaintext))
+	if err != nil {
+		return nil, fmt.Errorf("vault %q: %s", c.file, err)
+	}
+	v := &vault{secrets: make(map[string]string), salt: reader.header.salt}
+	for _, e := range vars {
+		v.secrets[e.name] = e.value
+	}
+	v.key = sameKeyAs(reader.header, key)
+	return v, nil
+}
+
+// lock waits for other updates of the vault to finish, and locks the
+// vault until unlock is called.
+func (c *vaultCmd) lock() (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(c.file), 0700); err != nil {
+		return nil, err
+	}
+	// The lock file is never removed, since another update may be
+	// waiting for the lock on it.
+	f, err := os.OpenFile(c.file+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock %q: %s", f.Name(), err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// save encrypts the vault and replaces the vault file. The vault must
+// be locked.
+func (c *vaultCmd) save(ctx context.Context, v *vault) error {
+	// Don't overwrite the changes of a command that doesn't lock the
+	// vault, like sym edit.
+	salt, err := vaultSalt(c.file)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(salt, v.salt) {
+		return fmt.Errorf("vault %q was changed while updating it, try again", c.file)
+	}
+	fOut, err := createOutputFile(c.file, true)
+	if err != nil {
+		return err
+	}
+	defer fOut.abort()
+	if err := fOut.Chmod(0600); err != nil {
+		return err
+	}
+	writer := newKeyedEncryptingWriter(ctx, fOut, v.key)
+	if _, err := io.WriteString(writer, formatDotenv(v.vars())); err != nil {
+		return fmt.Errorf("encrypt %q: %s", c.file, err)
+	}
+	if err := writer.close(); err != nil {
+		return fmt.Errorf("encrypt %q: %s", c.file, err)
+	}
+	return fOut.commit()
+}
+
+// readValue reads the value of a secret from the terminal, or from
+// stdin without the final newline.
+func (c *vaultCmd) readValue(name string) (string, error) {
+	if !isTerminal(c.stdin) {
+		b, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimSuffix(string(b), "\n")
+		return strings.TrimSuffix(value, "\r"), nil
+	}
+	value, err := c.keys.passwordIn(fmt.Sprintf("Enter value for %s: ", name))
+	if err != nil {
+		return "", err
+	}
+	confirm, err := c.keys.passwordIn("Repeat value: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != value {
+		return "", usageErr("values do not match")
+	}
+	return value, nil
+}
+
+func (c *vaultCmd) set(ctx context.Context, name string) error {
+	if !validEnvName(name) {
+		return usageErr("invalid secret name %q", name)
+	}
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	v, err := c.open(ctx, true)
+	if err != nil {
+		return err
+	}
+	value, err := c.readValue(name)
+	if err != nil {
+		return err
+	}
+	v.secrets[name] = value
+	return c.save(ctx, v)
+}
+
+func (c *vaultCmd) get(ctx context.Context, name string) error {
+	v, err := c.open(ctx, false)
+	if err != nil {
+		return err
+	}
+	value, ok := v.secrets[name]
+	if !ok {
+		return fmt.Errorf("no secret %q in the vault", name)
+	}
+	if isTerminal(c.stdout) {
+		value += "\n"
+	}
+	_, err = io.WriteString(c.stdout, value)
+	return err
+}
+
+func (c *vaultCmd) list(ctx context.Context) error {
+	v, err := c.open(ctx, false)
+	if err != nil {
+		return err
+	}
+	for _, e := range v.vars() {
+		fmt.Fprintln(c.stdout, e.name)
+	}
+	return nil
+}
+
+func (c *vaultCmd) remove(ctx context.Context, names []string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	v, err := c.open(ctx, false)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, ok := v.secrets[name]; !ok {
+			return fmt.Errorf("no secret %q in the vault", name)
+		}
+		delete(v.secrets, name)
+	}
+	return c.save(ctx, v)
+}
+
+func (c *vaultCmd) importFiles(ctx context.Context, fileNames []string) error {
+	var vars []envVar
+	read := func(name string, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		fileVars, err := parseDotenv(string(b))
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		vars = append(vars, fileVars...)
+		return nil
+	}
+	if len(fileNames) == 0 {
+		if err := read("stdin", c.stdin); err != nil {
+			return err
+		}
+	}
+	for _, fileName := range fileNames {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		err = read(fmt.Sprintf("%q", fileName), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	v, err := c.open(ctx, true)
+	if err != nil {
+		return err
+	}
+	for _, e := range vars {
+		v.secrets[e.name] = e.value
+	}
+	if err := c.save(ctx, v); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d secrets\n", len(vars))
+	return nil
+}
+
+func (c *vaultCmd) export(ctx context.Context) error {
+	v, err := c.open(ctx, false)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(c.stdout, formatDotenv(v.vars()))
+	return err
+}
+
+func (c *vaultCmd) run(ctx context.Context, args ...string) error {
+	if len(args) == 0 {
+		return usageErr("vault requires a command (set, get, list, rm, import or export)")
+	}
+	if c.file == "" {
+		return usageErr("no vault file (use -vault or set $%s)", vaultEnv)
+	}
+	if err := c.keys.check(); err != nil {
+		return err
+	}
+	// Use the file that a symlinked vault points to, so that the lock
+	// file is next to it, whichever name the vault is used with.
+	if file, err := filepath.EvalSymlinks(c.file); err == nil {
+		c.file = file
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	command, args := args[0], args[1:]
+	switch {
+	case command == "set" && len(args) == 1:
+		return c.set(ctx, args[0])
+	case command == "get" && len(args) == 1:
+		return c.get(ctx, args[0])
+	case command == "list" && len(args) == 0:
+		return c.list(ctx)
+	case command == "rm" && len(args) > 0:
+		return c.remove(ctx, args)
+	case command == "import":
+		return c.importFiles(ctx, args)
+	case command == "export" && len(args) == 0:
+		return c.export(ctx)
+	case slices.Contains([]string{"set", "get", "list", "rm", "export"}, command):
+		return usageErr("wrong number of arguments for vault %s", command)
+	}
+	return usageErr("unknown vault command %q", command)
+}
+
+func (c *vaultCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	args := f.Args()
+	if len(args) > 0 {
+		// Options can also come after the command.
+		var err error
+		if args, err = parseAfterCommand(f, args); err != nil {
+			return subcommands.ExitUsageError
+		}
+	}
+	return exitStatus(ctx, c.run(ctx, args...))
+}
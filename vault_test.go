@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// vaultRun runs a vault command and returns its output.
+func vaultRun(t *testing.T, c vaultCmd, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	c.stdin = strings.NewReader(stdin)
+	c.stdout = &stdout
+	err := c.run(t.Context(), args...)
+	return stdout.String(), err
+}
+
+func TestVaultCmd_Run(t *testing.T) {
+	t.Parallel()
+
+	c := vaultCmd{
+		file:      filepath.Join(t.TempDir(), "sym", "vault.enc"),
+		keys:      keyFlags{password: "asdf"},
+		allowWeak: true,
+	}
+	for _, step := range []struct {
+		args    []string
+		stdin   string
+		want    string
+		wantErr bool
+	}{
+		{args: []string{"list"}, wantErr: true},
+		{args: []string{"set", "token"}, stdin: "s3cret\n"},
+		{args: []string{"set", "db.password"}, stdin: "a \"quoted\" $value\nwith lines"},
+		{args: []string{"get", "token"}, want: "s3cret"},
+		{args: []string{"get", "db.password"}, want: "a \"quoted\" $value\nwith lines"},
+		{args: []string{"list"}, want: "db.password\ntoken\n"},
+		{args: []string{"set", "token"}, stdin: "new"},
+		{args: []string{"get", "token"}, want: "new"},
+		{args: []string{"rm", "token"}},
+		{args: []string{"get", "token"}, wantErr: true},
+		{args: []string{"rm", "token"}, wantErr: true},
+		{args: []string{"import"}, stdin: "export A=1\nB='two words'\n"},
+		{args: []string{"export"}, want: "A=\"1\"\nB=\"two words\"\ndb.password=\"a \\\"quoted\\\" \\$value\\nwith lines\"\n"},
+		{args: []string{"set", "1invalid"}, stdin: "x", wantErr: true},
+		{args: []string{"get"}, wantErr: true},
+		{args: []string{"unknown"}, wantErr: true},
+		{wantErr: true},
+	} {
+		got, err := vaultRun(t, c, step.stdin, step.args...)
+		if gotErr := err != nil; gotErr != step.wantErr {
+			t.Fatalf("vault %q returned error %v, want error? %t", step.args, err, step.wantErr)
+		}
+		if got != step.want {
+			t.Errorf("vault %q printed %q, want %q", step.args, got, step.want)
+		}
+	}
+
+	info, err := os.Stat(c.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("Vault has mode %v, want 0600", mode)
+	}
+
+	// The vault is an encrypted dotenv file.
+	_, plaintext, err := decryptFile(t.Context(), c.file, passwordKey("asdf"))
+	if err != nil {
+		t.Fatalf("decryptFile failed: %s", err)
+	}
+	vars, err := parseDotenv(string(plaintext))
+	if err != nil {
+		t.Fatalf("parseDotenv failed: %s", err)
+	}
+	want := []envVar{{"A", "1"}, {"B", "two words"}, {"db.password", "a \"quoted\" $value\nwith lines"}}
+	if !slices.Equal(vars, want) {
+		t.Errorf("Vault has variables %q, want %q", vars, want)
+	}
+
+	c.keys.password = "wrong"
+	if _, err := vaultRun(t, c, "", "list"); err == nil {
+		t.Error("vault list succeeded with the wrong password")
+	}
+}
+
+func TestVaultCmd_Run_Weak(t *testing.T) {
+	t.Parallel()
+
+	c := vaultCmd{
+		file: filepath.Join(t.TempDir(), "vault.enc"),
+		keys: keyFlags{password: "asdf"},
+	}
+	if _, err := vaultRun(t, c, "x", "set", "a"); err == nil {
+		t.Error("vault set created a vault with a weak password")
+	}
+	if _, err := os.Stat(c.file); err == nil {
+		t.Error("vault set wrote the vault after an error")
+	}
+}
+
+func TestVaultCmd_Run_Keyfile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "key")
+	mustWriteFile(t, keyfile, []byte("keyfile contents"))
+	c := vaultCmd{
+		file:      filepath.Join(dir, "vault.enc"),
+		keys:      keyFlags{password: "asdf", keyfiles: stringsFlag{keyfile}},
+		allowWeak: true,
+	}
+	for _, args := range [][]string{{"set", "a"}, {"set", "b"}} {
+		if _, err := vaultRun(t, c, "x", args...); err != nil {
+			t.Fatalf("vault %q failed: %s", args, err)
+		}
+	}
+	if got, err := vaultRun(t, c, "", "list"); err != nil || got != "a\nb\n" {
+		t.Errorf("vault list = %q, %v, want %q", got, err, "a\nb\n")
+	}
+	c.keys.keyfiles = nil
+	if _, err := vaultRun(t, c, "", "list"); err == nil {
+		t.Error("vault list succeeded without the keyfile")
+	}
+}
+
+func TestVaultCmd_Save_Changed(t *testing.T) {
+	t.Parallel()
+
+	c := &vaultCmd{
+		file:      filepath.Join(t.TempDir(), "vault.enc"),
+		keys:      keyFlags{password: "asdf"},
+		allowWeak: true,
+		stdin:     strings.NewReader("1"),
+	}
+	if err := c.run(t.Context(), "set", "a"); err != nil {
+		t.Fatalf("vault set failed: %s", err)
+	}
+	v1, err := c.open(t.Context(), false)
+	if err != nil {
+		t.Fatalf("open failed: %s", err)
+	}
+	v2, err := c.open(t.Context(), false)
+	if err != nil {
+		t.Fatalf("open failed: %s", err)
+	}
+	v1.secrets["b"] = "2"
+	if err := c.save(t.Context(), v1); err != nil {
+		t.Fatalf("save failed: %s", err)
+	}
+	v2.secrets["c"] = "3"
+	if err := c.save(t.Context(), v2); err == nil {
+		t.Error("save overwrote a vault that was changed")
+	}
+	v, err := c.open(t.Context(), false)
+	if err != nil {
+		t.Fatalf("open failed: %s", err)
+	}
+	if _, ok := v.secrets["b"]; !ok {
+		t.Errorf("Vault has secrets %q, want b", v.secrets)
+	}
+}
+
+func TestVaultCmd_Run_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	c := vaultCmd{
+		file:      filepath.Join(t.TempDir(), "vault.enc"),
+		keys:      keyFlags{password: "asdf"},
+		allowWeak: true,
+	}
+	var want strings.Builder
+	var wg sync.WaitGroup
+	for i := range 10 {
+		name := fmt.Sprintf("secret%d", i)
+		want.WriteString(name + "\n")
+		wg.Go(func() {
+			if _, err := vaultRun(t, c, "x", "set", name); err != nil {
+				t.Errorf("vault set %s failed: %s", name, err)
+			}
+		})
+	}
+	wg.Wait()
+	if got, err := vaultRun(t, c, "", "list"); err != nil || got != want.String() {
+		t.Errorf("vault list = %q, %v, want %q", got, err, want.String())
+	}
+}
+
+func TestVaultCmd_Run_Symlink(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "real.enc")
+	link := filepath.Join(dir, "link.enc")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("Failed to create symlink: %s", err)
+	}
+	c := vaultCmd{file: target, keys: keyFlags{password: "asdf"}, allowWeak: true}
+	if _, err := vaultRun(t, c, "1", "set", "a"); err != nil {
+		t.Fatalf("vault set failed: %s", err)
+	}
+	c.file = link
+	if _, err := vaultRun(t, c, "2", "set", "b"); err != nil {
+		t.Fatalf("vault set through a symlink failed: %s", err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Error("vault set replaced the symlink")
+	}
+	if _, err := os.Stat(link + ".lock"); err == nil {
+		t.Error("vault set locked the symlink instead of the vault it points to")
+	}
+	c.file = target
+	if got, err := vaultRun(t, c, "", "list"); err != nil || got != "a\nb\n" {
+		t.Errorf("vault list = %q, %v, want %q", got, err, "a\nb\n")
+	}
+}